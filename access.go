@@ -0,0 +1,181 @@
+package cewrap
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// AccessAction is what an access rule does with a matching request.
+type AccessAction string
+
+const (
+	// AccessAllow passes the request.
+	AccessAllow AccessAction = "allow"
+	// AccessDeny blocks the request.
+	AccessDeny AccessAction = "deny"
+)
+
+// RequestBlockedEventType is the type suffix of the events for blocked requests.
+const RequestBlockedEventType = "request_blocked"
+
+// AccessRule allows or denies the requests with a path that matches one of
+// the patterns. A * in a pattern matches any text, including slashes.
+type AccessRule struct {
+	// Paths the rule applies to, relative to the path prefix.
+	Paths []string
+	// Methods the rule applies to, all methods when empty.
+	Methods []string
+	// Action for the matching requests.
+	Action AccessAction
+}
+
+// matches reports whether the rule applies to method and path.
+func (ar *AccessRule) matches(method, path string) bool {
+	if len(ar.Methods) > 0 {
+		found := false
+		for _, m := range ar.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchAny(ar.Paths, path)
+}
+
+// AccessControl blocks the requests to the paths that must not be exposed,
+// before they are handled.
+//
+// The rules are evaluated in order and the first matching rule decides.
+// The requests that match no rule are denied when a rule allows requests,
+// so allow rules form an allowlist, else they are passed.
+type AccessControl struct {
+	// Rules are the access rules.
+	Rules []AccessRule
+	// Default is the action for the requests that match no rule, see above when empty.
+	Default AccessAction
+	// Status of the response for a blocked request, 404 Not Found when 0 so the
+	// path is not revealed, or 403 Forbidden.
+	Status int
+	// Event emits a request_blocked event for the blocked requests, at most one per second.
+	Event bool
+
+	// Limits the request_blocked events.
+	eventLimiter *rate.Limiter
+}
+
+// validate checks the rules.
+func (a *AccessControl) validate() error {
+	var errs []error
+	for i, ar := range a.Rules {
+		if len(ar.Paths) == 0 {
+			errs = append(errs, fmt.Errorf("access rule %d: no paths", i))
+		}
+		if ar.Action != AccessAllow && ar.Action != AccessDeny {
+			errs = append(errs, fmt.Errorf("access rule %d: unknown action %q", i, ar.Action))
+		}
+	}
+	switch a.Default {
+	case "", AccessAllow, AccessDeny:
+	default:
+		errs = append(errs, fmt.Errorf("access: unknown default action %q", a.Default))
+	}
+	switch a.Status {
+	case 0, http.StatusNotFound, http.StatusForbidden:
+	default:
+		errs = append(errs, fmt.Errorf("access: status %d is not 404 or 403", a.Status))
+	}
+	return errors.Join(errs...)
+}
+
+// check returns the index of the rule that blocks method and path,
+// -1 when no rule matched, and false when the request is blocked.
+func (a *AccessControl) check(method, path string) (int, bool) {
+	allowlist := false
+	for i, ar := range a.Rules {
+		if ar.matches(method, path) {
+			return i, ar.Action == AccessAllow
+		}
+		allowlist = allowlist || ar.Action == AccessAllow
+	}
+	switch a.Default {
+	case AccessAllow:
+		return -1, true
+	case AccessDeny:
+		return -1, false
+	}
+	return -1, !allowlist
+}
+
+// status returns the status of the response for a blocked request.
+func (a *AccessControl) status() int {
+	if a.Status == 0 {
+		return http.StatusNotFound
+	}
+	return a.Status
+}
+
+// accessPath returns the path of r that the rules are matched against: the
+// cleaned path without the path prefix and the path parameters, so dot
+// segments, double slashes and ;parameters do not bypass the rules.
+// Servers such as Tomcat drop the path parameters, /actuator;x/env is
+// handled as /actuator/env.
+func accessPath(r *http.Request, prefix string) string {
+	segs := strings.Split(r.URL.Path, "/")
+	for i, seg := range segs {
+		segs[i], _, _ = strings.Cut(seg, ";")
+	}
+	up := strings.Join(segs, "/")
+	p := path.Clean("/" + up)
+	if strings.HasSuffix(up, "/") && p != "/" {
+		p += "/"
+	}
+	if strings.HasPrefix(p, prefix) {
+		p = p[len(prefix):]
+	}
+	return p
+}
+
+// checkAccess checks the access rules for r. It writes the response for
+// a blocked request and returns false.
+func (s *serviceRequest) checkAccess(w http.ResponseWriter, r *http.Request) bool {
+	a := s.s.access
+	path := accessPath(r, s.s.pathPrefix)
+	rule, ok := a.check(r.Method, path)
+	if ok {
+		return true
+	}
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	status := a.status()
+	s.logger.Warn("request blocked",
+		slog.String("method", r.Method),
+		slog.String("path", path),
+		slog.Int("rule", rule),
+		slog.String("client_ip", clientIP),
+	)
+	http.Error(w, http.StatusText(status), status)
+
+	if a.Event {
+		s.emitControlEvent(r, a.eventLimiter, RequestBlockedEventType, path, map[string]any{
+			"rule":     rule,
+			"status":   status,
+			"method":   r.Method,
+			"path":     path,
+			"clientIP": clientIP,
+		})
+	}
+	return false
+}
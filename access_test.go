@@ -0,0 +1,191 @@
+package cewrap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessControl(t *testing.T) {
+	cases := []struct {
+		name   string
+		access *AccessControl
+		method string
+		path   string
+		want   int
+	}{
+		{
+			name:   "deny matches",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/actuator*"}, Action: AccessDeny}}},
+			method: http.MethodGet,
+			path:   "/actuator/env",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "deny passes the other paths",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/actuator*"}, Action: AccessDeny}}},
+			method: http.MethodGet,
+			path:   "/persons",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "dot segments are cleaned",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/actuator*"}, Action: AccessDeny}}},
+			method: http.MethodGet,
+			path:   "/persons/..//actuator/env",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "path parameters are removed",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/actuator", "/actuator/*"}, Action: AccessDeny}}},
+			method: http.MethodGet,
+			path:   "/actuator;x/env",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "empty segment with path parameters",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/actuator", "/actuator/*"}, Action: AccessDeny}}},
+			method: http.MethodGet,
+			path:   "/;/actuator/env",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "dot segment with path parameters",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/actuator", "/actuator/*"}, Action: AccessDeny}}},
+			method: http.MethodGet,
+			path:   "/persons/..;x/actuator/env",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "deny by method",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/persons*"}, Methods: []string{"DELETE"}, Action: AccessDeny}}, Status: http.StatusForbidden},
+			method: http.MethodDelete,
+			path:   "/persons/1",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "other methods pass",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/persons*"}, Methods: []string{"DELETE"}, Action: AccessDeny}}},
+			method: http.MethodPut,
+			path:   "/persons/1",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "allowlist passes",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/persons", "/persons/*"}, Action: AccessAllow}}},
+			method: http.MethodPost,
+			path:   "/persons",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "allowlist blocks the other paths",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/persons", "/persons/*"}, Action: AccessAllow}}},
+			method: http.MethodPost,
+			path:   "/admin",
+			want:   http.StatusNotFound,
+		},
+		{
+			name: "first match decides",
+			access: &AccessControl{Rules: []AccessRule{
+				{Paths: []string{"/persons/*/secrets"}, Action: AccessDeny},
+				{Paths: []string{"/persons*"}, Action: AccessAllow},
+			}},
+			method: http.MethodGet,
+			path:   "/persons/1/secrets",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "default allow",
+			access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/persons*"}, Action: AccessAllow}}, Default: AccessAllow},
+			method: http.MethodGet,
+			path:   "/orders",
+			want:   http.StatusOK,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSourceE(
+				WithMiddlewareMode(),
+				WithPathPrefix("/api"),
+				WithAccessControl(tc.access),
+			)
+			if !assert.NoError(t, err) {
+				return
+			}
+			called := false
+			h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(tc.method, "/api"+tc.path, nil))
+			assert.Equal(t, tc.want, rr.Code)
+			assert.Equal(t, tc.want == http.StatusOK, called)
+		})
+	}
+}
+
+func TestAccessControlEvent(t *testing.T) {
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithSink(sink),
+		WithSource("urn:test"),
+		WithTypePrefix("com.example"),
+		WithCEOverrides(&CEOverrides{Extensions: map[string]string{"cluster": "prod"}}),
+		WithAccessControl(&AccessControl{
+			Rules: []AccessRule{{Paths: []string{"/actuator*"}, Action: AccessDeny}},
+			Event: true,
+		}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/actuator/env", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case evt := <-echan:
+		assert.Equal(t, "com.example.request_blocked", evt.Type())
+		assert.Equal(t, "/actuator/env", evt.Subject())
+		assert.Equal(t, "prod", evt.Extensions()["cluster"])
+		assert.JSONEq(t, `{"rule":0,"status":404,"method":"GET","path":"/actuator/env","clientIP":"10.0.0.1"}`, string(evt.Data()))
+	case <-time.After(time.Second):
+		t.Fatal("no request_blocked event")
+	}
+
+	// The next blocked requests within a second are not reported.
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/actuator/env", nil))
+	}
+	select {
+	case evt := <-echan:
+		t.Fatalf("unexpected event %s", evt.ID())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestAccessControlErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		access *AccessControl
+		want   string
+	}{
+		{name: "no paths", access: &AccessControl{Rules: []AccessRule{{Action: AccessDeny}}}, want: "access rule 0: no paths"},
+		{name: "action", access: &AccessControl{Rules: []AccessRule{{Paths: []string{"/"}, Action: "drop"}}}, want: `access rule 0: unknown action "drop"`},
+		{name: "default", access: &AccessControl{Default: "drop"}, want: `access: unknown default action "drop"`},
+		{name: "status", access: &AccessControl{Status: 401}, want: "access: status 401 is not 404 or 403"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSourceE(WithMiddlewareMode(), WithAccessControl(tc.access))
+			assert.ErrorContains(t, err, tc.want)
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package cewrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the output format of the access log.
+type AccessLogFormat string
+
+const (
+	// AccessLogCombined writes the Apache Combined Log Format followed by
+	// the request id, event id, bytes in, downstream and total latency in ms.
+	AccessLogCombined AccessLogFormat = "combined"
+	// AccessLogJSON writes one json object per request.
+	AccessLogJSON AccessLogFormat = "json"
+)
+
+// AccessLogEntry holds the information of one proxied request.
+type AccessLogEntry struct {
+	Time              time.Time     `json:"time"`
+	ClientIP          string        `json:"client_ip"`
+	Method            string        `json:"method"`
+	URI               string        `json:"uri"`
+	Proto             string        `json:"proto"`
+	Status            int           `json:"status"`
+	BytesIn           int64         `json:"bytes_in"`
+	BytesOut          int64         `json:"bytes_out"`
+	DownstreamLatency time.Duration `json:"-"`
+	TotalLatency      time.Duration `json:"-"`
+	Referer           string        `json:"referer,omitempty"`
+	UserAgent         string        `json:"user_agent,omitempty"`
+	RequestID         string        `json:"request_id,omitempty"`
+	EventID           string        `json:"event_id,omitempty"`
+}
+
+// AccessLog writes an access log record per proxied request.
+//
+// It is separate from the diagnostic logger of the Source and is safe for concurrent use.
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format AccessLogFormat
+}
+
+// NewAccessLog returns an AccessLog that writes to w in the given format.
+func NewAccessLog(w io.Writer, format AccessLogFormat) *AccessLog {
+	return &AccessLog{w: w, format: format}
+}
+
+// Log writes e to the access log.
+func (a *AccessLog) Log(e *AccessLogEntry) error {
+	var line []byte
+	switch a.format {
+	case AccessLogJSON:
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		line = append(b, '\n')
+	default:
+		line = []byte(combinedLine(e))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err := a.w.Write(line)
+	return err
+}
+
+// MarshalJSON writes the latencies in ms.
+func (e *AccessLogEntry) MarshalJSON() ([]byte, error) {
+	type entry AccessLogEntry
+	return json.Marshal(struct {
+		*entry
+		DownstreamLatency float64 `json:"downstream_latency_ms"`
+		TotalLatency      float64 `json:"total_latency_ms"`
+	}{
+		entry:             (*entry)(e),
+		DownstreamLatency: ms(e.DownstreamLatency),
+		TotalLatency:      ms(e.TotalLatency),
+	})
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// combinedLine formats e in the Combined Log Format with the cewrap fields appended.
+func combinedLine(e *AccessLogEntry) string {
+	bytesOut := "-"
+	if e.BytesOut > 0 {
+		bytesOut = fmt.Sprint(e.BytesOut)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s \"%s\" \"%s\" \"%s\" \"%s\" %d %.3f %.3f\n",
+		dash(e.ClientIP),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		escape(e.Method), escape(e.URI), escape(e.Proto),
+		e.Status,
+		bytesOut,
+		dash(escape(e.Referer)),
+		dash(escape(e.UserAgent)),
+		dash(escape(e.RequestID)),
+		dash(escape(e.EventID)),
+		e.BytesIn,
+		ms(e.DownstreamLatency),
+		ms(e.TotalLatency),
+	)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escape escapes the quotes and backslashes in s and writes the control
+// characters as \xhh, like Apache does, so a value cannot end its field or line.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// logAccess writes the access log entry for the request r.
+func (s *Source) logAccess(svcReq *serviceRequest, sw *statusWriter, r *http.Request, start time.Time) {
+	e := &AccessLogEntry{
+		Time:              start,
+		ClientIP:          clientIP(r),
+		Method:            r.Method,
+		URI:               r.RequestURI,
+		Proto:             r.Proto,
+		Status:            sw.status,
+		BytesIn:           svcReq.bytesIn,
+		BytesOut:          sw.written,
+		DownstreamLatency: svcReq.downstreamLatency,
+		TotalLatency:      time.Since(start),
+		Referer:           r.Referer(),
+		UserAgent:         r.UserAgent(),
+		RequestID:         svcReq.requestID,
+		EventID:           svcReq.eventID,
+	}
+	if err := s.accessLog.Log(e); err != nil {
+		s.logger.Warn("error writing access log", slog.String("err", err.Error()))
+	}
+}
+
+// clientIP returns the host part of the remote address of r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
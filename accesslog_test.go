@@ -0,0 +1,111 @@
+package cewrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLogJSON(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("Hi there"))
+	}))
+	defer svr.Close()
+
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	buf := &bytes.Buffer{}
+	s := NewSource(
+		WithDownstream(svr.URL),
+		WithSink(sink),
+		WithSource("https://testservice.example.com/testapi"),
+		WithAccessLog(NewAccessLog(buf, AccessLogJSON)),
+	)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/testapi/path?q=1", bytes.NewBufferString("Hallo daar"))
+	req.Header.Set("User-Agent", "test-agent")
+	s.Handler()(rr, req)
+	evt := <-echan
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("access log is not json: %s", err)
+	}
+	assert.Equal(t, "192.0.2.1", got["client_ip"])
+	assert.Equal(t, "POST", got["method"])
+	assert.Equal(t, "/testapi/path?q=1", got["uri"])
+	assert.Equal(t, float64(http.StatusCreated), got["status"])
+	assert.Equal(t, float64(10), got["bytes_in"])
+	assert.Equal(t, float64(8), got["bytes_out"])
+	assert.Equal(t, "test-agent", got["user_agent"])
+	assert.Equal(t, evt.ID(), got["event_id"])
+	assert.Contains(t, got, "downstream_latency_ms")
+	assert.Contains(t, got, "total_latency_ms")
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	buf := &bytes.Buffer{}
+	al := NewAccessLog(buf, AccessLogCombined)
+	err := al.Log(&AccessLogEntry{
+		Time:              time.Date(2023, 11, 20, 13, 55, 36, 0, time.UTC),
+		ClientIP:          "192.0.2.1",
+		Method:            http.MethodPut,
+		URI:               "/persons/1",
+		Proto:             "HTTP/1.1",
+		Status:            http.StatusOK,
+		BytesIn:           12,
+		BytesOut:          2326,
+		DownstreamLatency: 1500 * time.Microsecond,
+		TotalLatency:      2 * time.Millisecond,
+		UserAgent:         `curl "8"`,
+		RequestID:         "req-1",
+	})
+	assert.NoError(t, err)
+	want := `192.0.2.1 - - [20/Nov/2023:13:55:36 +0000] "PUT /persons/1 HTTP/1.1" 200 2326 "-" "curl \"8\"" "req-1" "-" 12 1.500 2.000`
+	assert.Equal(t, want, strings.TrimSuffix(buf.String(), "\n"))
+
+	// The request line cannot break the fields or the line.
+	buf.Reset()
+	err = al.Log(&AccessLogEntry{
+		Time:     time.Date(2023, 11, 20, 13, 55, 36, 0, time.UTC),
+		ClientIP: "192.0.2.1",
+		Method:   "GET\n",
+		URI:      `/a" 200 1 "x\`,
+		Proto:    "HTTP/2.0",
+		Status:   http.StatusOK,
+	})
+	assert.NoError(t, err)
+	want = `192.0.2.1 - - [20/Nov/2023:13:55:36 +0000] "GET\x0a /a\" 200 1 \"x\\ HTTP/2.0" 200 - "-" "-" "-" "-" 0 0.000 0.000`
+	assert.Equal(t, want, strings.TrimSuffix(buf.String(), "\n"))
+}
+
+func TestAccessLogNoWrite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithAccessLog(NewAccessLog(buf, AccessLogJSON)),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The handler writes nothing, net/http sends 200 OK.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	s.Middleware(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/persons", nil))
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("access log is not json: %s", err)
+	}
+	assert.Equal(t, float64(http.StatusOK), got["status"])
+	assert.Equal(t, float64(0), got["bytes_out"])
+}
@@ -0,0 +1,103 @@
+package cewrap
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+)
+
+// BodyLimits limits the size and the media type of the request bodies,
+// checked before the requests are handled.
+//
+// Requests with a larger body get 413 Content Too Large, requests with a
+// body of another media type 415 Unsupported Media Type.
+type BodyLimits struct {
+	// MaxBytes is the max size of a request body, no limit when 0.
+	MaxBytes int64
+	// Routes have their own max size, the first route with a matching path applies.
+	Routes []RouteBodyLimit
+	// ContentTypes are the accepted media types of the request bodies, e.g.
+	// application/json, all types when empty. A * matches any text.
+	ContentTypes []string
+}
+
+// RouteBodyLimit is the max size of the request bodies for the requests with
+// a path that matches one of the patterns. A * in a pattern matches any text.
+type RouteBodyLimit struct {
+	Paths []string
+	// MaxBytes is the max size of a request body, no limit when 0.
+	MaxBytes int64
+}
+
+// validate checks the limits.
+func (b *BodyLimits) validate() error {
+	var errs []error
+	if b.MaxBytes < 0 {
+		errs = append(errs, errors.New("body limits: max bytes is negative"))
+	}
+	for i, rl := range b.Routes {
+		if len(rl.Paths) == 0 {
+			errs = append(errs, fmt.Errorf("body limits: route %d has no paths", i))
+		}
+		if rl.MaxBytes < 0 {
+			errs = append(errs, fmt.Errorf("body limits: route %d max bytes is negative", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// maxBytes returns the max size of the request bodies for path, 0 for no limit.
+func (b *BodyLimits) maxBytes(path string) int64 {
+	for _, rl := range b.Routes {
+		if matchAny(rl.Paths, path) {
+			return rl.MaxBytes
+		}
+	}
+	return b.MaxBytes
+}
+
+// allowContentType reports whether the media type of the content type ct is accepted.
+func (b *BodyLimits) allowContentType(ct string) bool {
+	if len(b.ContentTypes) == 0 {
+		return true
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return matchAnyFold(b.ContentTypes, mt)
+}
+
+// hasBody reports whether r has a body, possibly of unknown length.
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// checkBody checks the body of r against the limits. It writes the response
+// for a rejected request and returns false. A body that turns out to be
+// larger while it is read fails with an *http.MaxBytesError.
+func (s *serviceRequest) checkBody(w http.ResponseWriter, r *http.Request) bool {
+	b := s.s.bodyLimits
+	if !hasBody(r) {
+		return true
+	}
+	if ct := r.Header.Get("Content-Type"); !b.allowContentType(ct) {
+		s.logger.Warn("unsupported media type", slog.String("content_type", ct))
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return false
+	}
+	path := accessPath(r, s.s.pathPrefix)
+	max := b.maxBytes(path)
+	if max == 0 {
+		return true
+	}
+	if r.ContentLength > max {
+		s.logger.Warn("request body too large", slog.Int64("content_length", r.ContentLength), slog.Int64("max", max))
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, max)
+	return true
+}
@@ -0,0 +1,90 @@
+package cewrap
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLimits(t *testing.T) {
+	calls := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	s, err := NewSourceE(
+		WithDownstream(svr.URL),
+		WithPathPrefix("/api"),
+		WithBodyLimits(&BodyLimits{
+			MaxBytes: 10,
+			Routes: []RouteBodyLimit{
+				{Paths: []string{"/uploads*"}, MaxBytes: 20},
+				{Paths: []string{"/imports*"}},
+			},
+			ContentTypes: []string{"application/json", "application/*+json"},
+		}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := s.Handler()
+
+	cases := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		contentType string
+		chunked     bool
+		want        int
+		wantCalled  bool
+	}{
+		{name: "small body", method: http.MethodPost, path: "/persons", body: `{"a":1}`, contentType: "application/json", want: http.StatusOK, wantCalled: true},
+		{name: "content length too large", method: http.MethodPost, path: "/persons", body: `{"a":"0123456789"}`, contentType: "application/json", want: http.StatusRequestEntityTooLarge},
+		{name: "chunked too large", method: http.MethodPost, path: "/persons", body: `{"a":"0123456789"}`, contentType: "application/json", chunked: true, want: http.StatusRequestEntityTooLarge},
+		{name: "route limit", method: http.MethodPut, path: "/uploads/1", body: `{"a":"0123456789"}`, contentType: "application/json", want: http.StatusOK, wantCalled: true},
+		{name: "route limit of the clean path", method: http.MethodPut, path: "//uploads;x/1", body: `{"a":"0123456789"}`, contentType: "application/json", want: http.StatusOK, wantCalled: true},
+		{name: "route without limit of the clean path", method: http.MethodPut, path: "/persons/../imports", body: strings.Repeat(" ", 100), contentType: "application/json", want: http.StatusOK, wantCalled: true},
+		{name: "route without limit", method: http.MethodPut, path: "/imports", body: strings.Repeat(" ", 100), contentType: "application/json", want: http.StatusOK, wantCalled: true},
+		{name: "media type with parameters", method: http.MethodPost, path: "/persons", body: `{}`, contentType: "application/json; charset=utf-8", want: http.StatusOK, wantCalled: true},
+		{name: "media type pattern", method: http.MethodPost, path: "/persons", body: `{}`, contentType: "application/cloudevents+json", want: http.StatusOK, wantCalled: true},
+		{name: "other media type", method: http.MethodPost, path: "/persons", body: `a=1`, contentType: "application/x-www-form-urlencoded", want: http.StatusUnsupportedMediaType},
+		{name: "no media type", method: http.MethodPost, path: "/persons", body: `{}`, want: http.StatusUnsupportedMediaType},
+		{name: "no body", method: http.MethodGet, path: "/persons", want: http.StatusOK, wantCalled: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls = 0
+			req := httptest.NewRequest(tc.method, "/api"+tc.path, strings.NewReader(tc.body))
+			if tc.body == "" {
+				req = httptest.NewRequest(tc.method, "/api"+tc.path, nil)
+			}
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			assert.Equal(t, tc.want, rr.Code)
+			assert.Equal(t, tc.wantCalled, calls == 1)
+		})
+	}
+}
+
+func TestBodyLimitsErrors(t *testing.T) {
+	_, err := NewSourceE(WithMiddlewareMode(), WithBodyLimits(&BodyLimits{
+		MaxBytes: -1,
+		Routes:   []RouteBodyLimit{{MaxBytes: 10}},
+	}))
+	assert.ErrorContains(t, err, "body limits: max bytes is negative")
+	assert.ErrorContains(t, err, "body limits: route 0 has no paths")
+}
@@ -0,0 +1,44 @@
+package cewrap
+
+import (
+	"context"
+	"fmt"
+
+	cesql "github.com/cloudevents/sdk-go/sql/v2"
+	cesqlparser "github.com/cloudevents/sdk-go/sql/v2/parser"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// CESQLFilter returns a filter that accepts the events for which the
+// CloudEvents SQL expression evaluates to true, for example
+//
+//	type = 'com.example.patch_handled' AND myext = 'foo'
+//
+// An expression that fails to evaluate, e.g. on a missing extension,
+// does not accept the event.
+func CESQLFilter(expression string) (f EventFilter, err error) {
+	// The parser panics on some syntax errors.
+	defer func() {
+		if r := recover(); r != nil {
+			f, err = nil, fmt.Errorf("error parsing filter %q: %v", expression, r)
+		}
+	}()
+	expr, err := cesqlparser.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing filter %q: %w", expression, err)
+	}
+	return cesqlFilter{expr: expr}, nil
+}
+
+type cesqlFilter struct {
+	expr cesql.Expression
+}
+
+func (f cesqlFilter) FilterEvent(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool {
+	v, err := f.expr.Evaluate(*evt)
+	if err != nil {
+		return false
+	}
+	b, ok := v.(bool)
+	return ok && b
+}
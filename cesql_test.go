@@ -0,0 +1,40 @@
+package cewrap
+
+import (
+	"context"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCESQLFilter(t *testing.T) {
+	evt := cloudevents.NewEvent()
+	evt.SetID("1")
+	evt.SetSource("urn:test")
+	evt.SetType("x.patch_handled")
+	evt.SetSubject("/persons/1")
+	evt.SetExtension("myext", "foo")
+
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{"subject LIKE '/health%'", false},
+		{"subject LIKE '/persons/%'", true},
+		{"type = 'x.patch_handled' AND myext = 'foo'", true},
+		{"type = 'x.patch_handled' AND myext = 'bar'", false},
+		{"missing = 'foo'", false},
+		{"NOT (subject LIKE '/health%')", true},
+	}
+	for _, cc := range cases {
+		f, err := CESQLFilter(cc.expr)
+		if !assert.NoError(t, err, cc.expr) {
+			continue
+		}
+		assert.Equal(t, cc.want, f.FilterEvent(context.Background(), &evt, &EventInfo{}), cc.expr)
+	}
+
+	_, err := CESQLFilter("subject LIKE")
+	assert.Error(t, err)
+}
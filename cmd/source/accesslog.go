@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/myhops/cewrap"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// newAccessLog creates the access log from the options.
+//
+// It returns nil when the access log is disabled.
+// A file is rotated when it reaches the max size.
+func newAccessLog(o *options) *cewrap.AccessLog {
+	var w io.Writer
+	switch o.accessLog {
+	case "":
+		return nil
+	case "stdout", "-":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		maxSize, _ := strconv.Atoi(o.accessLogMaxSize)
+		maxBackups, _ := strconv.Atoi(o.accessLogMaxBackups)
+		w = &lumberjack.Logger{
+			Filename:   o.accessLog,
+			MaxSize:    maxSize,
+			MaxBackups: maxBackups,
+		}
+	}
+	return cewrap.NewAccessLog(w, cewrap.AccessLogFormat(o.accessLogFormat))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/myhops/cewrap"
+)
+
+// limitMetrics counts the limited requests of all sources, so the counts survive a reload.
+var limitMetrics = &cewrap.LimitMetrics{}
+
+// newAdminHandler returns the handler for the admin endpoints.
+//
+//	GET /loglevel returns the current log level.
+//	PUT /loglevel sets the log level to the level in the body, e.g. debug.
+//	GET /metrics returns the limit metrics in the Prometheus text format.
+func newAdminHandler(level *slog.LevelVar, logger *slog.Logger, metrics *cewrap.LimitMetrics) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, metrics)
+	})
+	mux.HandleFunc("/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var l slog.Level
+			if err := l.UnmarshalText([]byte(strings.TrimSpace(string(body)))); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			old := level.Level()
+			level.Set(l)
+			logger.Warn("log level changed",
+				slog.String("from", old.String()),
+				slog.String("to", l.String()),
+			)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, strings.ToLower(level.Level().String())+"\n")
+	})
+	return mux
+}
+
+// writeMetrics writes the metrics in the Prometheus text format.
+func writeMetrics(w io.Writer, m *cewrap.LimitMetrics) {
+	fmt.Fprintln(w, "# HELP cewrap_requests_in_flight Requests that are handled, counted when limits are set.")
+	fmt.Fprintln(w, "# TYPE cewrap_requests_in_flight gauge")
+	fmt.Fprintf(w, "cewrap_requests_in_flight %d\n", m.InFlight())
+	fmt.Fprintln(w, "# HELP cewrap_requests_limited_total Requests rejected by a rate or concurrency limit.")
+	fmt.Fprintln(w, "# TYPE cewrap_requests_limited_total counter")
+	for _, c := range m.Limited() {
+		fmt.Fprintf(w, "cewrap_requests_limited_total{limit=%s,reason=%s} %d\n",
+			strconv.Quote(c.Limit), strconv.Quote(c.Reason), c.Count)
+	}
+}
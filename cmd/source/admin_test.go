@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/myhops/cewrap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminLogLevel(t *testing.T) {
+	level := new(slog.LevelVar)
+	h := newAdminHandler(level, slog.Default(), &cewrap.LimitMetrics{})
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "info\n", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader("debug")))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, slog.LevelDebug, level.Level())
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader("loud")))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, slog.LevelDebug, level.Level())
+}
+
+func TestAdminMetrics(t *testing.T) {
+	cf := writeConfig(t, "config.yaml", `
+downstream: http://example.com
+sink: http://example.com/sink
+limits:
+  maxInFlight: 10
+  rateLimit:
+    rate: 100
+routes:
+  - paths: ["/persons*"]
+    rateLimit:
+      rate: 0.1
+      by: clientIP
+`)
+	opts, err := getOptionsFrom([]string{"-config", cf}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := opts.getLimits()
+	if !assert.NotNil(t, l) || !assert.Len(t, l.RateLimits, 2) {
+		return
+	}
+	assert.Equal(t, "routes[0]", l.RateLimits[0].Name)
+	assert.Equal(t, 1, l.RateLimits[0].Burst)
+	assert.Equal(t, "default", l.RateLimits[1].Name)
+	assert.Equal(t, 100, l.RateLimits[1].Burst)
+
+	metrics := &cewrap.LimitMetrics{}
+	l.Metrics = metrics
+	l.State = &cewrap.LimitState{}
+	s, err := cewrap.NewSourceE(cewrap.WithMiddlewareMode(), cewrap.WithLimits(l))
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/persons", nil))
+	}
+
+	rr := httptest.NewRecorder()
+	newAdminHandler(new(slog.LevelVar), slog.Default(), metrics).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "cewrap_requests_in_flight 0\n")
+	assert.Contains(t, rr.Body.String(), `cewrap_requests_limited_total{limit="routes[0]",reason="rate"} 1`)
+}
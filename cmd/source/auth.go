@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// authOptions configures the authentication of the requests to the sinks.
+//
+// The secrets can be set directly or in files, the files are read
+// again when they change so rotated secrets are used without a restart.
+type authOptions struct {
+	// Static bearer token.
+	tokenFile string
+
+	// OAuth2 client credentials flow.
+	tokenURL         string
+	clientID         string
+	clientSecret     string
+	clientSecretFile string
+	// Scopes separated by a comma or a space.
+	scopes string
+
+	// Basic auth.
+	username     string
+	password     string
+	passwordFile string
+}
+
+// method returns the authentication method, bearer, oauth2, basic or none.
+func (a *authOptions) method() string {
+	switch {
+	case a.tokenFile != "":
+		return "bearer"
+	case a.tokenURL != "":
+		return "oauth2"
+	case a.username != "":
+		return "basic"
+	}
+	return "none"
+}
+
+// validate checks the options, the errors are qualified with path.
+func (a *authOptions) validate(path string) []error {
+	var errs []error
+	n := 0
+	for _, set := range []bool{
+		a.tokenFile != "",
+		a.tokenURL != "" || a.clientID != "" || a.clientSecret != "" || a.clientSecretFile != "",
+		a.username != "" || a.password != "" || a.passwordFile != "",
+	} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		errs = append(errs, fieldError(path, errors.New("set only one of tokenFile, oauth2 and basic")))
+	}
+	checkFile := func(field, name string) {
+		if name == "" {
+			return
+		}
+		if _, err := readSecret(name); err != nil {
+			errs = append(errs, fieldError(path+"."+field, err))
+		}
+	}
+	checkFile("tokenFile", a.tokenFile)
+
+	if a.tokenURL != "" || a.clientID != "" || a.clientSecret != "" || a.clientSecretFile != "" {
+		if a.tokenURL == "" {
+			errs = append(errs, fieldError(path+".oauth2.tokenURL", errors.New("not set")))
+		} else if err := validateURL(a.tokenURL); err != nil {
+			errs = append(errs, fieldError(path+".oauth2.tokenURL", err))
+		}
+		if a.clientID == "" {
+			errs = append(errs, fieldError(path+".oauth2.clientID", errors.New("not set")))
+		}
+		if a.clientSecret == "" && a.clientSecretFile == "" {
+			errs = append(errs, fieldError(path+".oauth2.clientSecretFile", errors.New("not set")))
+		}
+		checkFile("oauth2.clientSecretFile", a.clientSecretFile)
+	}
+
+	if a.username != "" || a.password != "" || a.passwordFile != "" {
+		if a.username == "" {
+			errs = append(errs, fieldError(path+".basic.username", errors.New("not set")))
+		}
+		if a.password == "" && a.passwordFile == "" {
+			errs = append(errs, fieldError(path+".basic.passwordFile", errors.New("not set")))
+		}
+		checkFile("basic.passwordFile", a.passwordFile)
+	}
+	return errs
+}
+
+// transport returns base with the authentication added to the requests,
+// base when no authentication is set.
+func (a *authOptions) transport(base http.RoundTripper) http.RoundTripper {
+	switch a.method() {
+	case "bearer":
+		token := newSecretReloader("", a.tokenFile)
+		return authTransport(base, func(r *http.Request) error {
+			t, err := token.get()
+			if err != nil {
+				return err
+			}
+			r.Header.Set("Authorization", "Bearer "+t)
+			return nil
+		})
+	case "oauth2":
+		// The token endpoint is called with the same transport, without the authentication.
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: base})
+		ts := oauth2.ReuseTokenSource(nil, &clientCredentials{
+			ctx: ctx,
+			cfg: clientcredentials.Config{
+				ClientID: a.clientID,
+				TokenURL: a.tokenURL,
+				Scopes:   strings.FieldsFunc(a.scopes, func(r rune) bool { return r == ',' || r == ' ' }),
+			},
+			secret: newSecretReloader(a.clientSecret, a.clientSecretFile),
+		})
+		return authTransport(base, func(r *http.Request) error {
+			t, err := ts.Token()
+			if err != nil {
+				return fmt.Errorf("error getting oauth2 token: %w", err)
+			}
+			t.SetAuthHeader(r)
+			return nil
+		})
+	case "basic":
+		password := newSecretReloader(a.password, a.passwordFile)
+		return authTransport(base, func(r *http.Request) error {
+			p, err := password.get()
+			if err != nil {
+				return err
+			}
+			r.SetBasicAuth(a.username, p)
+			return nil
+		})
+	}
+	return base
+}
+
+// roundTripperFunc is a function that implements http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// authTransport returns base with set applied to a clone of each request.
+func authTransport(base http.RoundTripper, set func(*http.Request) error) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		if err := set(r); err != nil {
+			if r.Body != nil {
+				r.Body.Close()
+			}
+			return nil, err
+		}
+		return base.RoundTrip(r)
+	})
+}
+
+// clientCredentials is a token source for the client credentials flow
+// that uses the current client secret.
+type clientCredentials struct {
+	ctx    context.Context
+	cfg    clientcredentials.Config
+	secret *secretReloader
+}
+
+func (c *clientCredentials) Token() (*oauth2.Token, error) {
+	s, err := c.secret.get()
+	if err != nil {
+		return nil, err
+	}
+	cfg := c.cfg
+	cfg.ClientSecret = s
+	return cfg.Token(c.ctx)
+}
+
+// readSecret reads the secret in name, without the surrounding white space.
+func readSecret(name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return "", fmt.Errorf("%s is empty", name)
+	}
+	return s, nil
+}
+
+// secretReloader holds a secret that is set directly or read from a file,
+// the file is read again when it changes.
+type secretReloader struct {
+	name string
+
+	mu     sync.Mutex
+	mod    time.Time
+	secret string
+}
+
+func newSecretReloader(secret, name string) *secretReloader {
+	return &secretReloader{secret: secret, name: name}
+}
+
+// get returns the current secret. When the changed file cannot be read,
+// e.g. halfway a rotation, it keeps the previous secret.
+func (s *secretReloader) get() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.name == "" {
+		return s.secret, nil
+	}
+	mod := modTime(s.name)
+	if s.secret != "" && mod.Equal(s.mod) {
+		return s.secret, nil
+	}
+	secret, err := readSecret(s.name)
+	if err != nil {
+		if s.secret != "" {
+			return s.secret, nil
+		}
+		return "", fmt.Errorf("error reading secret: %w", err)
+	}
+	s.secret, s.mod = secret, mod
+	return s.secret, nil
+}
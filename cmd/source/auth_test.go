@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newAuthSink starts a sink that records the Authorization headers.
+func newAuthSink(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+	var (
+		mu      sync.Mutex
+		headers []string
+	)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(svr.Close)
+	return svr, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), headers...)
+	}
+}
+
+func sendTestEvent(t *testing.T, o *options, target string) error {
+	t.Helper()
+	c, err := o.newSinkClient(target)
+	if err != nil {
+		return err
+	}
+	evt := cloudevents.NewEvent()
+	evt.SetID("1")
+	evt.SetSource("urn:test")
+	evt.SetType("test")
+	if result := c.Send(context.Background(), evt); !cloudevents.IsACK(result) {
+		return result
+	}
+	return nil
+}
+
+func TestSinkAuthBearer(t *testing.T) {
+	svr, headers := newAuthSink(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeFile(t, tokenFile, []byte("token1\n"))
+
+	o := &options{sinkAuth: authOptions{tokenFile: tokenFile}}
+	assert.Empty(t, o.sinkAuth.validate("sinkAuth"))
+	c, err := o.newSinkClient(svr.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	send := func() {
+		evt := cloudevents.NewEvent()
+		evt.SetID("1")
+		evt.SetSource("urn:test")
+		evt.SetType("test")
+		assert.True(t, cloudevents.IsACK(c.Send(context.Background(), evt)))
+	}
+	send()
+
+	// A rotated token is used for the next request.
+	writeFile(t, tokenFile, []byte("token2"))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(tokenFile, later, later)
+	send()
+	assert.Equal(t, []string{"Bearer token1", "Bearer token2"}, headers())
+}
+
+func TestSinkAuthOAuth2(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		calls     int
+		expiresIn = 3600
+	)
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if id != "cewrap" || secret != "s3cret" || r.Form.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "token" + string(rune('0'+calls)),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+			"scope":        r.Form.Get("scope"),
+		})
+	}))
+	defer tokens.Close()
+	svr, headers := newAuthSink(t)
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	writeFile(t, secretFile, []byte("s3cret"))
+
+	o := &options{sinkAuth: authOptions{
+		tokenURL:         tokens.URL,
+		clientID:         "cewrap",
+		clientSecretFile: secretFile,
+		scopes:           "events.write",
+	}}
+	assert.Empty(t, o.sinkAuth.validate("sinkAuth"))
+	c, err := o.newSinkClient(svr.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	send := func() error {
+		evt := cloudevents.NewEvent()
+		evt.SetID("1")
+		evt.SetSource("urn:test")
+		evt.SetType("test")
+		if result := c.Send(context.Background(), evt); !cloudevents.IsACK(result) {
+			return result
+		}
+		return nil
+	}
+
+	// The token is cached.
+	assert.NoError(t, send())
+	assert.NoError(t, send())
+	assert.Equal(t, []string{"Bearer token1", "Bearer token1"}, headers())
+
+	// A token that expires is refreshed.
+	mu.Lock()
+	calls, expiresIn = 1, 1
+	mu.Unlock()
+	c, _ = o.newSinkClient(svr.URL)
+	assert.NoError(t, send())
+	assert.NoError(t, send())
+	assert.Equal(t, []string{"Bearer token1", "Bearer token1", "Bearer token2", "Bearer token3"}, headers())
+
+	// A wrong secret fails the delivery.
+	writeFile(t, secretFile, []byte("wrong"))
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(secretFile, later, later)
+	assert.ErrorContains(t, send(), "error getting oauth2 token")
+}
+
+func TestSinkAuthBasic(t *testing.T) {
+	svr, headers := newAuthSink(t)
+	o := &options{sinkAuth: authOptions{username: "cewrap", password: "s3cret"}}
+	assert.NoError(t, sendTestEvent(t, o, svr.URL))
+	assert.Equal(t, []string{"Basic Y2V3cmFwOnMzY3JldA=="}, headers())
+}
+
+func TestSinkAuthErrors(t *testing.T) {
+	o := &authOptions{
+		tokenFile:    filepath.Join(t.TempDir(), "missing"),
+		tokenURL:     "/token",
+		passwordFile: "",
+		password:     "s3cret",
+	}
+	var msgs []string
+	for _, err := range o.validate("sinkAuth") {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Contains(t, msgs, "sinkAuth: set only one of tokenFile, oauth2 and basic")
+	assert.Contains(t, msgs, `sinkAuth.oauth2.tokenURL: "/token" is not an absolute url`)
+	assert.Contains(t, msgs, "sinkAuth.oauth2.clientID: not set")
+	assert.Contains(t, msgs, "sinkAuth.oauth2.clientSecretFile: not set")
+	assert.Contains(t, msgs, "sinkAuth.basic.username: not set")
+	assert.Len(t, msgs, 6)
+}
+
+func TestSinkAuthNotLogged(t *testing.T) {
+	var buf bytes.Buffer
+	o := &options{sinkAuth: authOptions{
+		tokenURL:     "http://example.com/token",
+		clientID:     "cewrap",
+		clientSecret: "s3cret",
+		password:     "passw0rd",
+	}}
+	logOptions(o, slog.New(slog.NewTextHandler(&buf, nil)))
+	assert.Contains(t, buf.String(), "sinkAuth=oauth2")
+	assert.NotContains(t, buf.String(), "s3cret")
+	assert.NotContains(t, buf.String(), "passw0rd")
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/myhops/cewrap"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the layout of the configuration file.
+//
+// The file is in YAML or JSON format and is described by config.schema.json.
+// Fields that are not set in the file keep their defaults, env vars and
+// flags overrule the values from the file.
+type fileConfig struct {
+	Downstream      string   `yaml:"downstream"`
+	Port            string   `yaml:"port"`
+	Sink            string   `yaml:"sink"`
+	Source          string   `yaml:"source"`
+	Dataschema      string   `yaml:"dataschema"`
+	TypePrefix      string   `yaml:"typePrefix"`
+	PathPrefix      string   `yaml:"pathPrefix"`
+	ChangeMethods   []string `yaml:"changeMethods"`
+	ExtraMethods    []string `yaml:"extraMethods"`
+	RequestIDHeader string   `yaml:"requestIdHeader"`
+	AdminPort       string   `yaml:"adminPort"`
+	HTTPSPort       string   `yaml:"httpsPort"`
+	Filter          string   `yaml:"filter"`
+
+	Log struct {
+		Format   string `yaml:"format"`
+		Level    string `yaml:"level"`
+		Sampling string `yaml:"sampling"`
+	} `yaml:"log"`
+
+	AccessLog struct {
+		Destination string `yaml:"destination"`
+		Format      string `yaml:"format"`
+		MaxSize     string `yaml:"maxSize"`
+		MaxBackups  string `yaml:"maxBackups"`
+	} `yaml:"accessLog"`
+
+	Tracing struct {
+		Exporter string `yaml:"exporter"`
+	} `yaml:"tracing"`
+
+	// TLS configures the listener.
+	TLS tlsConfig `yaml:"tls"`
+
+	// Limits configures the concurrency limit and the rate limit for the
+	// requests without a route rate limit, and the request bodies.
+	Limits struct {
+		MaxInFlight  string          `yaml:"maxInFlight"`
+		RateLimit    rateLimitConfig `yaml:"rateLimit"`
+		Event        string          `yaml:"event"`
+		MaxBodySize  string          `yaml:"maxBodySize"`
+		ContentTypes []string        `yaml:"contentTypes"`
+	} `yaml:"limits"`
+
+	// Access configures the paths that are exposed.
+	Access struct {
+		DenyPaths  []string           `yaml:"denyPaths"`
+		AllowPaths []string           `yaml:"allowPaths"`
+		Rules      []accessRuleConfig `yaml:"rules"`
+		Default    string             `yaml:"default"`
+		Status     string             `yaml:"status"`
+		Event      string             `yaml:"event"`
+	} `yaml:"access"`
+
+	// CORS configures the answers to the preflights and the CORS headers.
+	CORS struct {
+		AllowedOrigins   []string `yaml:"allowedOrigins"`
+		AllowedMethods   []string `yaml:"allowedMethods"`
+		AllowedHeaders   []string `yaml:"allowedHeaders"`
+		ExposedHeaders   []string `yaml:"exposedHeaders"`
+		AllowCredentials string   `yaml:"allowCredentials"`
+		MaxAge           string   `yaml:"maxAge"`
+	} `yaml:"cors"`
+
+	// JWT configures the check of the bearer tokens of the requests.
+	JWT struct {
+		Mode     string `yaml:"mode"`
+		JWKSFile string `yaml:"jwksFile"`
+		JWKSURL  string `yaml:"jwksURL"`
+		Issuer   string `yaml:"issuer"`
+		Audience string `yaml:"audience"`
+		Optional string `yaml:"optional"`
+	} `yaml:"jwt"`
+
+	Sinks []sinkConfig `yaml:"sinks"`
+
+	// SinkTLS configures the connections to all sinks.
+	SinkTLS tlsConfig `yaml:"sinkTLS"`
+	// SinkAuth configures the authentication to all sinks,
+	// the secrets are read from files.
+	SinkAuth struct {
+		TokenFile string `yaml:"tokenFile"`
+		OAuth2    struct {
+			TokenURL         string   `yaml:"tokenURL"`
+			ClientID         string   `yaml:"clientID"`
+			ClientSecretFile string   `yaml:"clientSecretFile"`
+			Scopes           []string `yaml:"scopes"`
+		} `yaml:"oauth2"`
+		Basic struct {
+			Username     string `yaml:"username"`
+			PasswordFile string `yaml:"passwordFile"`
+		} `yaml:"basic"`
+	} `yaml:"sinkAuth"`
+
+	// DownstreamTLS and DownstreamTransport configure the connections to the downstream service.
+	DownstreamTLS       tlsConfig `yaml:"downstreamTLS"`
+	DownstreamTransport struct {
+		MaxIdleConnsPerHost string `yaml:"maxIdleConnsPerHost"`
+		IdleConnTimeout     string `yaml:"idleConnTimeout"`
+		HTTP2               string `yaml:"http2"`
+	} `yaml:"downstreamTransport"`
+
+	Transform *transformConfig `yaml:"transform"`
+	Routes    []routeConfig    `yaml:"routes"`
+
+	Extensions []extensionConfig `yaml:"extensions"`
+
+	Data struct {
+		Mode            string   `yaml:"mode"`
+		Headers         []string `yaml:"headers"`
+		MaxRequestSize  string   `yaml:"maxRequestSize"`
+		MaxResponseSize string   `yaml:"maxResponseSize"`
+	} `yaml:"data"`
+
+	Redaction struct {
+		Salt  string             `yaml:"salt"`
+		Rules []redactRuleConfig `yaml:"rules"`
+	} `yaml:"redaction"`
+
+	Reload struct {
+		PollInterval string `yaml:"pollInterval"`
+		Event        string `yaml:"event"`
+	} `yaml:"reload"`
+}
+
+// sinkConfig configures an extra sink with its delivery settings and routing.
+type sinkConfig struct {
+	Name       string `yaml:"name"`
+	URL        string `yaml:"url"`
+	Timeout    string `yaml:"timeout"`
+	Retries    int    `yaml:"retries"`
+	RetryDelay string `yaml:"retryDelay"`
+
+	// Filter routes the events, all set fields must match.
+	Filter struct {
+		Types      []string          `yaml:"types"`
+		Subjects   []string          `yaml:"subjects"`
+		Extensions map[string]string `yaml:"extensions"`
+		// SQL is a CloudEvents SQL expression.
+		SQL string `yaml:"sql"`
+	} `yaml:"filter"`
+}
+
+// tlsConfig configures a TLS connection.
+type tlsConfig struct {
+	CACerts    string `yaml:"caCerts"`
+	CAFile     string `yaml:"caFile"`
+	CertFile   string `yaml:"certFile"`
+	KeyFile    string `yaml:"keyFile"`
+	MinVersion string `yaml:"minVersion"`
+
+	// Only used for the client connections.
+	ServerName         string `yaml:"serverName"`
+	InsecureSkipVerify string `yaml:"insecureSkipVerify"`
+
+	// Only used for the listener.
+	ClientAuth string `yaml:"clientAuth"`
+}
+
+// apply copies the values that are set to o.
+func (c *tlsConfig) apply(o *tlsOptions) {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&o.caCerts, c.CACerts)
+	set(&o.caFile, c.CAFile)
+	set(&o.certFile, c.CertFile)
+	set(&o.keyFile, c.KeyFile)
+	set(&o.minVersion, c.MinVersion)
+	set(&o.serverName, c.ServerName)
+	set(&o.insecureSkipVerify, c.InsecureSkipVerify)
+	set(&o.clientAuth, c.ClientAuth)
+}
+
+// transformConfig configures the transformation of the JSON event data.
+type transformConfig struct {
+	Include  []string       `yaml:"include"`
+	Exclude  []string       `yaml:"exclude"`
+	Rename   []renameConfig `yaml:"rename"`
+	Envelope string         `yaml:"envelope"`
+	Add      map[string]any `yaml:"add"`
+}
+
+// renameConfig moves the field at From to To.
+type renameConfig struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+func (tc *transformConfig) dataTransform() *cewrap.DataTransform {
+	var rename []cewrap.FieldRename
+	for _, r := range tc.Rename {
+		rename = append(rename, cewrap.FieldRename{From: r.From, To: r.To})
+	}
+	return &cewrap.DataTransform{
+		Include:  tc.Include,
+		Exclude:  tc.Exclude,
+		Rename:   rename,
+		Envelope: tc.Envelope,
+		Add:      tc.Add,
+	}
+}
+
+// routeConfig configures the settings for the requests with a matching path.
+type routeConfig struct {
+	Paths     []string         `yaml:"paths"`
+	Transform *transformConfig `yaml:"transform"`
+	RateLimit *rateLimitConfig `yaml:"rateLimit"`
+	// MaxBodySize is the max size in bytes of the request bodies, 0 for no limit.
+	MaxBodySize string `yaml:"maxBodySize"`
+}
+
+// accessRuleConfig allows or denies the requests to the paths.
+type accessRuleConfig struct {
+	Paths []string `yaml:"paths"`
+	// Methods the rule applies to, all methods when empty.
+	Methods []string `yaml:"methods"`
+	// Action is allow or deny.
+	Action string `yaml:"action"`
+}
+
+// rateLimitConfig configures a token bucket rate limit.
+type rateLimitConfig struct {
+	// Rate is the number of requests per second.
+	Rate string `yaml:"rate"`
+	// Burst is the size of the bucket, defaults to the rate rounded up.
+	Burst string `yaml:"burst"`
+	// By is route, clientIP or header.
+	By     string `yaml:"by"`
+	Header string `yaml:"header"`
+}
+
+// validate checks the limit, the errors are qualified with path.
+func (rc *rateLimitConfig) validate(path string) []error {
+	var errs []error
+	if r, err := strconv.ParseFloat(rc.Rate, 64); err != nil || r <= 0 {
+		errs = append(errs, fieldError(path+".rate", fmt.Errorf("%q is not a positive number", rc.Rate)))
+	}
+	if rc.Burst != "" {
+		if b, err := strconv.ParseUint(rc.Burst, 10, 31); err != nil || b == 0 {
+			errs = append(errs, fieldError(path+".burst", fmt.Errorf("%q is not a positive number", rc.Burst)))
+		}
+	}
+	switch cewrap.LimitBy(rc.By) {
+	case "", cewrap.LimitByRoute, cewrap.LimitByClientIP:
+		if rc.Header != "" {
+			errs = append(errs, fieldError(path+".header", errors.New("only used with by header")))
+		}
+	case cewrap.LimitByHeader:
+		if rc.Header == "" {
+			errs = append(errs, fieldError(path+".header", errors.New("not set, needed with by header")))
+		}
+	default:
+		errs = append(errs, fieldError(path+".by", fmt.Errorf("unknown key %q, use route, clientIP or header", rc.By)))
+	}
+	return errs
+}
+
+// rateLimit returns the limit for the paths.
+func (rc *rateLimitConfig) rateLimit(name string, paths []string) *cewrap.RateLimit {
+	rl := &cewrap.RateLimit{
+		Name:   name,
+		Paths:  paths,
+		By:     cewrap.LimitBy(rc.By),
+		Header: rc.Header,
+	}
+	rl.Rate, _ = strconv.ParseFloat(rc.Rate, 64)
+	rl.Burst, _ = strconv.Atoi(rc.Burst)
+	if rl.Burst == 0 {
+		rl.Burst = int(math.Ceil(rl.Rate))
+	}
+	return rl
+}
+
+// extensionConfig maps a value of the request or the response to an extension.
+type extensionConfig struct {
+	Name    string `yaml:"name"`
+	From    string `yaml:"from"`
+	Key     string `yaml:"key"`
+	Pattern string `yaml:"pattern"`
+}
+
+// redactRuleConfig configures a redaction rule.
+type redactRuleConfig struct {
+	Paths   []string `yaml:"paths"`
+	Keys    []string `yaml:"keys"`
+	Values  []string `yaml:"values"`
+	Headers []string `yaml:"headers"`
+	Action  string   `yaml:"action"`
+}
+
+// readConfigFile reads the configuration file name.
+func readConfigFile(name string) (*fileConfig, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	cfg, err := parseConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", name, err)
+	}
+	return cfg, nil
+}
+
+// parseConfig parses the YAML or JSON configuration in r.
+// Unknown fields are an error.
+func parseConfig(r io.Reader) (*fileConfig, error) {
+	cfg := &fileConfig{}
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// apply copies the values that are set in the file to o.
+func (c *fileConfig) apply(o *options) {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&o.downstream, c.Downstream)
+	set(&o.port, c.Port)
+	set(&o.sink, c.Sink)
+	set(&o.source, c.Source)
+	set(&o.dataschema, c.Dataschema)
+	set(&o.typePrefix, c.TypePrefix)
+	set(&o.pathPrefix, c.PathPrefix)
+	set(&o.requestIDHeader, c.RequestIDHeader)
+	set(&o.adminPort, c.AdminPort)
+	set(&o.httpsPort, c.HTTPSPort)
+	set(&o.maxInFlight, c.Limits.MaxInFlight)
+	set(&o.rateLimit.Rate, c.Limits.RateLimit.Rate)
+	set(&o.rateLimit.Burst, c.Limits.RateLimit.Burst)
+	set(&o.rateLimit.By, c.Limits.RateLimit.By)
+	set(&o.rateLimit.Header, c.Limits.RateLimit.Header)
+	set(&o.rateLimitedEvent, c.Limits.Event)
+	set(&o.maxBodySize, c.Limits.MaxBodySize)
+	set(&o.contentTypes, strings.Join(c.Limits.ContentTypes, ","))
+	set(&o.denyPaths, strings.Join(c.Access.DenyPaths, ","))
+	set(&o.allowPaths, strings.Join(c.Access.AllowPaths, ","))
+	set(&o.accessDefault, c.Access.Default)
+	set(&o.blockedStatus, c.Access.Status)
+	set(&o.blockedEvent, c.Access.Event)
+	set(&o.corsOrigins, strings.Join(c.CORS.AllowedOrigins, ","))
+	set(&o.corsMethods, strings.Join(c.CORS.AllowedMethods, ","))
+	set(&o.corsHeaders, strings.Join(c.CORS.AllowedHeaders, ","))
+	set(&o.corsExposedHeaders, strings.Join(c.CORS.ExposedHeaders, ","))
+	set(&o.corsCredentials, c.CORS.AllowCredentials)
+	set(&o.corsMaxAge, c.CORS.MaxAge)
+	set(&o.jwtMode, c.JWT.Mode)
+	set(&o.jwtJWKSFile, c.JWT.JWKSFile)
+	set(&o.jwtJWKSURL, c.JWT.JWKSURL)
+	set(&o.jwtIssuer, c.JWT.Issuer)
+	set(&o.jwtAudience, c.JWT.Audience)
+	set(&o.jwtOptional, c.JWT.Optional)
+	set(&o.logFormat, c.Log.Format)
+	set(&o.logLevel, c.Log.Level)
+	set(&o.logSampling, c.Log.Sampling)
+	set(&o.accessLog, c.AccessLog.Destination)
+	set(&o.accessLogFormat, c.AccessLog.Format)
+	set(&o.accessLogMaxSize, c.AccessLog.MaxSize)
+	set(&o.accessLogMaxBackups, c.AccessLog.MaxBackups)
+	set(&o.traceExporter, c.Tracing.Exporter)
+	set(&o.filter, c.Filter)
+	set(&o.redactionSalt, c.Redaction.Salt)
+	set(&o.dataMode, c.Data.Mode)
+	set(&o.dataMaxRequestSize, c.Data.MaxRequestSize)
+	set(&o.dataMaxResponseSize, c.Data.MaxResponseSize)
+	set(&o.configPollInterval, c.Reload.PollInterval)
+	set(&o.reloadEvent, c.Reload.Event)
+
+	if len(c.Sinks) > 0 {
+		o.sinks = c.Sinks
+	}
+	c.TLS.apply(&o.tls)
+	c.SinkTLS.apply(&o.sinkTLS)
+	set(&o.sinkAuth.tokenFile, c.SinkAuth.TokenFile)
+	set(&o.sinkAuth.tokenURL, c.SinkAuth.OAuth2.TokenURL)
+	set(&o.sinkAuth.clientID, c.SinkAuth.OAuth2.ClientID)
+	set(&o.sinkAuth.clientSecretFile, c.SinkAuth.OAuth2.ClientSecretFile)
+	set(&o.sinkAuth.scopes, strings.Join(c.SinkAuth.OAuth2.Scopes, ","))
+	set(&o.sinkAuth.username, c.SinkAuth.Basic.Username)
+	set(&o.sinkAuth.passwordFile, c.SinkAuth.Basic.PasswordFile)
+	c.DownstreamTLS.apply(&o.downstreamTLS)
+	set(&o.downstreamMaxIdleConnsPerHost, c.DownstreamTransport.MaxIdleConnsPerHost)
+	set(&o.downstreamIdleConnTimeout, c.DownstreamTransport.IdleConnTimeout)
+	set(&o.downstreamHTTP2, c.DownstreamTransport.HTTP2)
+	if c.Transform != nil {
+		o.transform = c.Transform
+	}
+	if len(c.Routes) > 0 {
+		o.routes = c.Routes
+	}
+	if len(c.Extensions) > 0 {
+		o.extensions = c.Extensions
+	}
+	if len(c.Data.Headers) > 0 {
+		o.dataHeaders = c.Data.Headers
+	}
+	if len(c.Redaction.Rules) > 0 {
+		o.redactRules = c.Redaction.Rules
+	}
+	if len(c.Access.Rules) > 0 {
+		o.accessRules = c.Access.Rules
+	}
+	if len(c.ChangeMethods) > 0 {
+		o.setChangeMethods(strings.Join(c.ChangeMethods, ","))
+	}
+	if len(c.ExtraMethods) > 0 {
+		o.appendChangeMethods(strings.Join(c.ExtraMethods, ","))
+	}
+}
+
+// configFileName returns the config file name from the flags or else from the env.
+func configFileName(args, env []string) (string, error) {
+	fo := &options{}
+	if err := fo.parseArgs(args); err != nil {
+		return "", err
+	}
+	if fo.configFile != "" {
+		return fo.configFile, nil
+	}
+	eo := &options{}
+	eo.getEnv(env)
+	return eo.configFile, nil
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/myhops/cewrap"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatalf("error writing config: %s", err)
+	}
+	return p
+}
+
+func TestConfigFilePrecedence(t *testing.T) {
+	cf := writeConfig(t, "config.yaml", `
+downstream: http://example.com/file
+sink: http://example.com/sinkfile
+port: 7070
+typePrefix: com.example.file
+changeMethods: [POST, PUT]
+log:
+  level: debug
+accessLog:
+  destination: stdout
+  format: json
+`)
+	env := []string{
+		"CEW_CONFIG=" + cf,
+		"K_SINK=http://example.com/sinkenv",
+		"PORT=9090",
+	}
+	args := []string{
+		"-port", "6060",
+	}
+
+	opts, err := getOptionsFrom(args, env)
+	assert.NoError(t, err)
+	assert.Equal(t, cf, opts.configFile)
+	assert.Equal(t, "http://example.com/file", opts.downstream)
+	assert.Equal(t, "http://example.com/sinkenv", opts.sink)
+	assert.Equal(t, "6060", opts.port)
+	assert.Equal(t, "com.example.file", opts.typePrefix)
+	assert.Equal(t, []string{"POST", "PUT"}, opts.changeMethods)
+	assert.Equal(t, "debug", opts.logLevel)
+	assert.Equal(t, "json", opts.accessLogFormat)
+}
+
+func TestConfigFileJSON(t *testing.T) {
+	cf := writeConfig(t, "config.json", `{
+	"downstream": "http://example.com/file",
+	"sink": "http://example.com/sink",
+	"extraMethods": ["GET"]
+}`)
+	opts, err := getOptionsFrom([]string{"-config", cf}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/file", opts.downstream)
+	assert.Equal(t, []string{"GET", "POST", "DELETE", "PATCH", "PUT"}, opts.changeMethods)
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "unknown field",
+			content: "downstreem: http://example.com\n",
+			want:    []string{"field downstreem not found"},
+		},
+		{
+			name: "invalid fields",
+			content: `
+downstream: example.com
+sink: http://example.com/sink
+port: eighty
+log:
+  level: loud
+accessLog:
+  format: common
+`,
+			want: []string{
+				`downstream: "example.com" is not an absolute url`,
+				`port: "eighty" is not a valid port number`,
+				`log.level: unknown log level "loud"`,
+				`accessLog.format: unknown access log format "common"`,
+			},
+		},
+		{
+			name: "invalid sinks",
+			content: `
+downstream: http://example.com
+sinks:
+  - name: broker
+    url: http://broker.example.com
+    timeout: 2s
+  - name: broker
+    url: audit.example.com
+    retries: -1
+    retryDelay: soon
+`,
+			want: []string{
+				`sinks[1].name: duplicate sink name "broker"`,
+				`sinks[1].url: "audit.example.com" is not an absolute url`,
+				`sinks[1].retries: -1 is negative`,
+				`sinks[1].retryDelay: "soon" is not a valid duration`,
+			},
+		},
+		{
+			name: "invalid filters",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+filter: "subject LIKE"
+sinks:
+  - url: http://audit.example.com
+    filter:
+      sql: "type = "
+`,
+			want: []string{
+				`filter: error parsing filter "subject LIKE"`,
+				`sinks[0].filter.sql: error parsing filter "type = "`,
+			},
+		},
+		{
+			name: "invalid transforms",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+transform:
+  include: ["id", "address..city"]
+routes:
+  - paths: ["persons/*"]
+    transform:
+      exclude: [""]
+      rename:
+        - from: id
+          to: person..id
+  - transform:
+      envelope: data
+`,
+			want: []string{
+				`transform.include[1]: "address..city" is not a valid field path`,
+				`routes[0].paths[0]: "persons/*" does not start with /`,
+				`routes[0].transform.exclude[0]: "" is not a valid field path`,
+				`routes[0].transform.rename[0].to: "person..id" is not a valid field path`,
+				`routes[1].paths: not set`,
+			},
+		},
+		{
+			name: "invalid redaction",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+redaction:
+  rules:
+    - keys: ["*password*"]
+      action: blur
+    - values: ["[0-9"]
+      action: hash
+    - action: remove
+`,
+			want: []string{
+				`redaction.rules[0].action: unknown action "blur"`,
+				`redaction.rules[1].values[0]: error parsing regexp`,
+				`redaction.rules[2]: no paths, keys, values or headers set`,
+				`redaction.salt: not set, the hash action needs a salt`,
+			},
+		},
+		{
+			name: "invalid data",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+data:
+  mode: all
+  maxRequestSize: -1
+  maxResponseSize: big
+`,
+			want: []string{
+				`data.mode: unknown data mode "all"`,
+				`data.maxRequestSize: "-1" is not a positive number`,
+				`data.maxResponseSize: "big" is not a positive number`,
+			},
+		},
+		{
+			name: "invalid extensions",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+extensions:
+  - name: tenant
+    from: requestHeader
+    key: X-Tenant
+  - name: tenant
+    from: status
+  - name: Person_ID
+    from: path
+    key: id
+    pattern: /persons/*
+  - name: etag
+    from: cookie
+  - name: location
+    from: responseHeader
+  - name: clientid
+    from: clientCert
+    key: cn
+  - name: clientdn
+    from: clientCert
+    key: subject
+`,
+			want: []string{
+				`extensions[1].name: duplicate extension "tenant"`,
+				`extensions[2].name: extension name "Person_ID" must only contain lowercase letters and digits`,
+				`extensions[2].pattern: "/persons/*" has no {id} segment`,
+				`extensions[3].from: unknown source "cookie"`,
+				`extensions[4].key: not set`,
+				`extensions[5].key: "cn" is not subject or san`,
+			},
+		},
+		{
+			name: "invalid jwt",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+jwt:
+  mode: validate
+  jwksURL: /jwks
+  optional: maybe
+extensions:
+  - name: actor
+    from: claim
+`,
+			want: []string{
+				`jwt.jwksURL: "/jwks" is not an absolute url`,
+				`jwt.optional: "maybe" is not a boolean`,
+				`extensions[0].key: not set`,
+			},
+		},
+		{
+			name: "invalid limits",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+limits:
+  maxInFlight: many
+  rateLimit:
+    rate: 0
+    by: user
+  event: maybe
+  maxBodySize: 1MB
+  contentTypes: ["json"]
+routes:
+  - paths: ["/persons"]
+    rateLimit:
+      rate: 10
+      burst: 0
+      by: header
+    maxBodySize: -1
+`,
+			want: []string{
+				`limits.maxBodySize: "1MB" is not a positive number`,
+				`limits.contentTypes[0]: "json" is not a media type`,
+				`routes[0].maxBodySize: "-1" is not a positive number`,
+				`limits.maxInFlight: "many" is not a positive number`,
+				`limits.rateLimit.rate: "0" is not a positive number`,
+				`limits.rateLimit.by: unknown key "user", use route, clientIP or header`,
+				`limits.event: "maybe" is not a boolean`,
+				`routes[0].rateLimit.burst: "0" is not a positive number`,
+				`routes[0].rateLimit.header: not set, needed with by header`,
+			},
+		},
+		{
+			name: "invalid access",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+access:
+  denyPaths: ["actuator*"]
+  rules:
+    - methods: ["DE LETE"]
+      action: block
+  default: none
+  status: 401
+  event: maybe
+`,
+			want: []string{
+				`access.denyPaths[0]: "actuator*" does not start with /`,
+				`access.rules[0].paths: not set`,
+				`access.rules[0].methods[0]: "DE LETE" is not a valid method`,
+				`access.rules[0].action: "block" is not allow or deny`,
+				`access.default: "none" is not allow or deny`,
+				`access.status: "401" is not 404 or 403`,
+				`access.event: "maybe" is not a boolean`,
+			},
+		},
+		{
+			name: "invalid cors",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+changeMethods: [POST, OPTIONS]
+cors:
+  allowedOrigins: ["app.example.com"]
+  allowCredentials: maybe
+  maxAge: forever
+`,
+			want: []string{
+				`changeMethods[1]: OPTIONS cannot be a change method`,
+				`cors.allowedOrigins[0]: "app.example.com" is not an http or https origin`,
+				`cors.allowCredentials: "maybe" is not a boolean`,
+				`cors.maxAge: "forever" is not a valid duration`,
+			},
+		},
+		{
+			name: "cors wildcard with credentials",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+cors:
+  allowedOrigins: ["*", "https://*", "https://*.example.com"]
+  allowCredentials: true
+`,
+			want: []string{
+				`cors.allowedOrigins[0]: "*" matches any site, not allowed with allowCredentials`,
+				`cors.allowedOrigins[1]: "https://*" matches any site, not allowed with allowCredentials`,
+			},
+		},
+		{
+			name: "cors without origins",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+cors:
+  allowedMethods: [GET]
+`,
+			want: []string{
+				`cors.allowedOrigins: not set, needed for the other cors settings`,
+			},
+		},
+		{
+			name: "jwt without mode",
+			content: `
+downstream: http://example.com
+sink: http://example.com/sink
+jwt:
+  issuer: https://login.example.com
+`,
+			want: []string{"jwt.mode: not set, needed with the other jwt settings"},
+		},
+	}
+
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			cf := writeConfig(t, "config.yaml", cc.content)
+			_, err := getOptionsFrom([]string{"-config", cf}, nil)
+			if !assert.Error(t, err) {
+				return
+			}
+			for _, w := range cc.want {
+				assert.Contains(t, err.Error(), w)
+			}
+		})
+	}
+}
+
+func TestConfigFileSinks(t *testing.T) {
+	cf := writeConfig(t, "config.yaml", `
+downstream: http://example.com
+sinks:
+  - name: broker
+    url: http://broker.example.com
+  - name: audit
+    url: http://audit.example.com
+    timeout: 2s
+    retries: 3
+    filter:
+      types: ["com.example.delete_*"]
+      extensions:
+        tenant: acme
+      sql: "subject LIKE '/persons/%'"
+`)
+	opts, err := getOptionsFrom([]string{"-config", cf}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	sinks, err := opts.getSinks()
+	assert.NoError(t, err)
+	if assert.Len(t, sinks, 2) {
+		assert.Equal(t, "audit", sinks[1].Name)
+		assert.Equal(t, 2*time.Second, sinks[1].Timeout)
+		assert.Equal(t, 3, sinks[1].Retries)
+		assert.Len(t, sinks[1].Filters, 3)
+	}
+}
+
+func TestConfigFileTransform(t *testing.T) {
+	cf := writeConfig(t, "config.yaml", `
+downstream: http://example.com
+sink: http://example.com/sink
+transform:
+  exclude: [secret]
+routes:
+  - paths: ["/persons/*"]
+    transform:
+      include: [id, address.city]
+      rename:
+        - from: address.city
+          to: city
+      envelope: person
+      add:
+        version: 2
+`)
+	opts, err := getOptionsFrom([]string{"-config", cf}, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	m := opts.getTransforms()
+	if !assert.NotNil(t, m) {
+		return
+	}
+	run := func(path string) string {
+		evt := cloudevents.NewEvent()
+		evt.SetDataContentType(cloudevents.ApplicationJSON)
+		evt.DataEncoded = []byte(`{"id":1,"secret":"x","address":{"city":"Utrecht"}}`)
+		assert.NoError(t, m.MutateEvent(context.Background(), &evt, &cewrap.EventInfo{Path: path}))
+		return string(evt.Data())
+	}
+	assert.JSONEq(t, `{"person":{"id":1,"address":{},"city":"Utrecht"},"version":2}`, run("/persons/1"))
+	assert.JSONEq(t, `{"id":1,"address":{"city":"Utrecht"}}`, run("/orders/1"))
+}
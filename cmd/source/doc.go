@@ -2,7 +2,8 @@
 The source command implements a proxy server that emits cloud events when
 it successfully handled a method that changes the data in the downstream service.
 
-It logs to standard error, currently in structured text format and with Info log level.
+It logs to standard error in structured text or json format, with the level set by -log-level.
+The level can be changed at runtime with PUT /loglevel on the admin port.
 
 	Usage:
 
@@ -16,13 +17,87 @@ It logs to standard error, currently in structured text format and with Info log
 			-path-prefix /downstream/service
 
 	Options:
+		-config
+		-config-poll-interval
+		-reload-event
 		-downstream
+		-downstream-ca-file
+		-downstream-cert-file
+		-downstream-key-file
+		-downstream-tls-min-version
+		-downstream-server-name
+		-downstream-insecure-skip-verify
+		-downstream-max-idle-conns-per-host
+		-downstream-idle-conn-timeout
+		-downstream-http2
 		-sink
+		-sink-ca-file
+		-sink-cert-file
+		-sink-key-file
+		-sink-tls-min-version
+		-sink-token-file
+		-sink-oauth2-token-url
+		-sink-oauth2-client-id
+		-sink-oauth2-client-secret-file
+		-sink-oauth2-scopes
+		-sink-username
+		-sink-password-file
 		-source
 		-port
 		-dataschema
 		-type-prefix
 		-path-prefix
+		-request-id-header
+		-log-format
+		-log-level
+		-log-sampling
+		-access-log
+		-access-log-format
+		-access-log-max-size
+		-access-log-max-backups
+		-admin-port
+		-https-port
+		-tls-cert-file
+		-tls-key-file
+		-tls-client-ca-file
+		-tls-client-auth
+		-tls-min-version
+		-max-in-flight
+		-rate-limit
+		-rate-limit-burst
+		-rate-limit-by
+		-rate-limit-header
+		-rate-limited-event
+		-max-body-size
+		-content-types
+		-deny-paths
+		-allow-paths
+		-access-default
+		-blocked-status
+		-blocked-event
+		-cors-allowed-origins
+		-cors-allowed-methods
+		-cors-allowed-headers
+		-cors-exposed-headers
+		-cors-allow-credentials
+		-cors-max-age
+		-jwt-mode
+		-jwt-jwks-file
+		-jwt-jwks-url
+		-jwt-issuer
+		-jwt-audience
+		-jwt-optional
+		-trace-exporter
+		-filter
+		-data-mode
+
+The options can also be set in a YAML or JSON configuration file with -config,
+see config.schema.json. Env vars overrule the file and flags overrule the env vars.
+The configuration is reloaded on SIGHUP or, with -config-poll-interval, when the file changes.
+
+The redact command shows the effect of the redaction rules in the config file on a sample payload:
+
+	source redact -config config.yaml [-content-type application/json] sample.json
 
 And so on
 */
@@ -1,19 +1,22 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-
-	"github.com/myhops/cewrap"
+	"time"
 )
 
-func newLogger(o *options) *slog.Logger {
+// newLogger creates the logger with the level set in level.
+func newLogger(o *options, level *slog.LevelVar) *slog.Logger {
+	ho := &slog.HandlerOptions{Level: level}
 	var h slog.Handler
 	if o.logFormat == "text" {
-		h = slog.NewTextHandler(os.Stderr, nil)
+		h = slog.NewTextHandler(os.Stderr, ho)
 	} else {
-		h = slog.NewJSONHandler(os.Stderr, nil)
+		h = slog.NewJSONHandler(os.Stderr, ho)
 	}
 
 	return slog.New(h).With(
@@ -28,39 +31,91 @@ func logOptions(o *options, l *slog.Logger) {
 		slog.String("pathPrefix", o.pathPrefix),
 		slog.String("port", o.port),
 		slog.String("sink", o.sink),
+		slog.Int("sinks", len(o.sinks)),
+		// Only the method, never the secrets.
+		slog.String("sinkAuth", o.sinkAuth.method()),
+		slog.Int("redactionRules", len(o.redactRules)),
+		slog.String("dataMode", o.dataMode),
 		slog.String("source", o.source),
 		slog.String("typePrefix", o.typePrefix),
 		slog.String("logFormat", o.logFormat),
 		slog.String("logLevel", o.logLevel),
+		slog.String("requestIDHeader", o.requestIDHeader),
+		slog.String("logSampling", o.logSampling),
+		slog.String("adminPort", o.adminPort),
+		slog.String("httpsPort", o.httpsPort),
+		slog.String("tlsCertFile", o.tls.certFile),
+		slog.String("tlsClientAuth", o.tls.clientAuth),
+		slog.String("jwtMode", o.jwtMode),
+		slog.String("maxInFlight", o.maxInFlight),
+		slog.String("rateLimit", o.rateLimit.Rate),
+		slog.String("maxBodySize", o.maxBodySize),
+		slog.String("contentTypes", o.contentTypes),
+		slog.String("denyPaths", o.denyPaths),
+		slog.String("allowPaths", o.allowPaths),
+		slog.Int("accessRules", len(o.accessRules)),
+		slog.String("corsAllowedOrigins", o.corsOrigins),
+		slog.String("accessLog", o.accessLog),
+		slog.String("accessLogFormat", o.accessLogFormat),
+		slog.String("traceExporter", o.traceExporter),
+		slog.String("configFile", o.configFile),
+		slog.String("configPollInterval", o.configPollInterval),
+		slog.String("reloadEvent", o.reloadEvent),
 	)
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "redact" {
+		if err := runRedact(os.Args[2:], os.Environ(), os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	opts, err := getOptions()
 	if err != nil {
 		slog.Default().Error("failed to get options", slog.String("err", err.Error()))
 		return
 	}
-	logger := newLogger(opts)
+	// The level can be changed at runtime with the admin endpoint.
+	level := new(slog.LevelVar)
+	level.UnmarshalText([]byte(opts.logLevel))
+	logger := newLogger(opts, level)
 
-	so, err := opts.getSourceOptions()
+	// Set up tracing before the source is created.
+	shutdownTracing, err := newTracerProvider(context.Background(), opts)
 	if err != nil {
-		logger.Error("error creating sink", slog.String("err", err.Error()))
+		logger.Error("error creating tracer provider", slog.String("err", err.Error()))
 		os.Exit(1)
 	}
+	defer shutdownTracing(context.Background())
 
-	// Add the logger.
-	so = append(so, cewrap.WithLogger(logger))
-	// Create the source.
-	s := cewrap.NewSource(so...)
+	// Create the source, it is replaced when the config is reloaded.
+	rl, err := newReloader(os.Args[1:], os.Environ(), opts, logger, level, newAccessLog(opts))
+	if err != nil {
+		logger.Error("error creating source", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+	pi, _ := time.ParseDuration(opts.configPollInterval)
+	go rl.watch(context.Background(), pi)
 
 	// Log the current options.
 	logOptions(opts, logger)
 
-	// Start server with the source.
-	la := ":" + opts.port
-	logger.Info("starting server", slog.String("listen_address", la))
-	if err := http.ListenAndServe(la, s.Handler()); err != nil {
+	// Start the admin server.
+	if opts.adminPort != "" {
+		aa := ":" + opts.adminPort
+		logger.Info("starting admin server", slog.String("listen_address", aa))
+		go func() {
+			if err := http.ListenAndServe(aa, newAdminHandler(level, logger, limitMetrics)); err != nil {
+				logger.Error("admin server stopped", slog.String("err", err.Error()))
+			}
+		}()
+	}
+
+	// Start the servers with the source.
+	if err := serve(opts, rl, logger); err != nil {
 		logger.Error("server stopped", slog.String("err", err.Error()))
 	}
 }
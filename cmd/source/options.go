@@ -1,20 +1,32 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/cloudevents/sdk-go/v2/client"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
 	"github.com/myhops/cewrap"
 )
 
 type options struct {
+	// Configuration file, YAML or JSON.
+	configFile string
+	// Interval for checking the config file for changes, disabled when empty.
+	configPollInterval string
+	// Emit a config_reloaded event after a reload, true or false.
+	reloadEvent string
+
 	downstream string
 	port       string
 	sink       string
@@ -25,17 +37,129 @@ type options struct {
 	logFormat  string
 	logLevel   string
 
+	// Header that carries the request id.
+	requestIDHeader string
+
+	// Log the per request records of one in logSampling requests.
+	logSampling string
+	// Port for the admin endpoints, disabled when empty.
+	adminPort string
+
+	// TLS for the listener, with optional client certificate verification.
+	tls tlsOptions
+	// Port for TLS next to plain HTTP on port, when empty TLS is served on port.
+	httpsPort string
+
+	// Bearer JWT check of the requests, validate or extract, disabled when empty.
+	jwtMode string
+	// Key set file or url, for the validate mode.
+	jwtJWKSFile string
+	jwtJWKSURL  string
+	// Required issuer and audience, not checked when empty.
+	jwtIssuer   string
+	jwtAudience string
+	// Pass the requests without a token, true or false.
+	jwtOptional string
+
+	// Access log destination, stdout, stderr or a file, disabled when empty.
+	accessLog string
+	// Access log format, combined or json.
+	accessLogFormat string
+	// Max size in MB of the access log file before it is rotated.
+	accessLogMaxSize string
+	// Max number of rotated access log files to keep, keep all when 0.
+	accessLogMaxBackups string
+
+	// Trace exporter, none, otlp or stdout.
+	traceExporter string
+
+	// CloudEvents SQL expression, only the events that match are sent.
+	filter string
+
+	// Extra sinks, only set in the config file.
+	sinks []sinkConfig
+	// TLS for the connections to the sinks.
+	sinkTLS tlsOptions
+	// Authentication of the requests to the sinks.
+	sinkAuth authOptions
+
+	// TLS and transport settings for the connections to the downstream service.
+	downstreamTLS                 tlsOptions
+	downstreamMaxIdleConnsPerHost string
+	downstreamIdleConnTimeout     string
+	// Use HTTP/2 when the downstream supports it, true or false.
+	downstreamHTTP2 string
+
+	// Transformation of the event data and the per route settings,
+	// only set in the config file.
+	transform *transformConfig
+	routes    []routeConfig
+
+	// Max number of requests handled at the same time, no limit when empty.
+	maxInFlight string
+	// Rate limit for the paths without a route rate limit.
+	rateLimit rateLimitConfig
+	// Emit a rate_limited event for the rejected requests, true or false.
+	rateLimitedEvent string
+	// Max size in bytes of the request bodies without a route max size, no limit when empty.
+	maxBodySize string
+	// Accepted media types of the request bodies separated by a comma, all when empty.
+	contentTypes string
+
+	// Paths that are blocked and paths that are exposed, separated by a comma.
+	denyPaths  string
+	allowPaths string
+	// Access rules, only set in the config file.
+	accessRules []accessRuleConfig
+	// Action for the requests that match no rule, allow or deny.
+	accessDefault string
+	// Status for the blocked requests, 404 or 403.
+	blockedStatus string
+	// Emit a request_blocked event for the blocked requests, true or false.
+	blockedEvent string
+
+	// CORS settings, the lists are separated by a comma.
+	// CORS is handled when the origins are set.
+	corsOrigins        string
+	corsMethods        string
+	corsHeaders        string
+	corsExposedHeaders string
+	// Allow requests with credentials, true or false.
+	corsCredentials string
+	// Duration the browsers cache a preflight.
+	corsMaxAge string
+
+	// Knative CloudEvents overrides, JSON from K_CE_OVERRIDES.
+	ceOverrides string
+
+	// Extensions set from the request and the response, only set in the config file.
+	extensions []extensionConfig
+
+	// Event data mode, response, request, both or auto.
+	dataMode string
+	// Max size in bytes of the request and the response body in the event.
+	dataMaxRequestSize  string
+	dataMaxResponseSize string
+	// Headers in the event data in the both mode, only set in the config file.
+	dataHeaders []string
+
+	// Salt for the hashed values, keep it secret.
+	redactionSalt string
+	// Redaction rules, only set in the config file.
+	redactRules []redactRuleConfig
+
 	changeMethods    []string
 	changeMethodsSet bool
 }
 
 // setChangeMethods sets the change methods from mlist.
-// 	mlist contains the methods separated by a comma.
+// It replaces the change methods set earlier, so a later source overrules.
+//
+//	mlist contains the methods separated by a comma.
 func (o *options) setChangeMethods(mlist string) {
-	if len(o.changeMethods) == 0 {
-		o.appendChangeMethods(mlist)
-		o.changeMethodsSet = true
-	}
+	o.changeMethods = nil
+	o.appendChangeMethods(mlist)
+	o.changeMethodsSet = true
 }
 
 // appendChangeMethods appends the methods in the list to the change methods.
@@ -44,10 +168,37 @@ func (o *options) appendChangeMethods(mlist string) {
 	o.changeMethods = append(o.changeMethods, m...)
 }
 
-// getOptionsFrom gets the options from the cli arguments and the environment vars.
+// splitList returns the elements of the comma separated list, without
+// the surrounding white space and the empty ones.
+func splitList(list string) []string {
+	var l []string
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// getOptionsFrom gets the options from the config file, the environment vars
+// and the cli arguments.
+//
+// The precedence is defaults < config file < env < cli arguments.
 func getOptionsFrom(args, env []string) (*options, error) {
 	opts := &options{}
-	// Env fist.
+	// Config file first.
+	cf, err := configFileName(args, env)
+	if err != nil {
+		return nil, err
+	}
+	if cf != "" {
+		cfg, err := readConfigFile(cf)
+		if err != nil {
+			return nil, err
+		}
+		cfg.apply(opts)
+	}
+	// Env overrules the file.
 	opts.getEnv(env)
 	// Args overrule
 	if err := opts.parseArgs(args); err != nil {
@@ -84,8 +235,62 @@ func (o *options) getEnv(env []string) error {
 			continue
 		}
 		switch k {
+		case "CEW_CONFIG":
+			o.configFile = v
+		case "CEW_CONFIG_POLL_INTERVAL":
+			o.configPollInterval = v
+		case "CEW_RELOAD_EVENT":
+			o.reloadEvent = v
 		case "K_SINK", "CEW_SINK":
 			o.sink = v
+		case "K_CE_OVERRIDES":
+			o.ceOverrides = v
+		case "K_SINK_CA_CERTS":
+			o.sinkTLS.caCerts = v
+		case "CEW_SINK_CA_FILE":
+			o.sinkTLS.caFile = v
+		case "CEW_SINK_CERT_FILE":
+			o.sinkTLS.certFile = v
+		case "CEW_SINK_KEY_FILE":
+			o.sinkTLS.keyFile = v
+		case "CEW_SINK_TLS_MIN_VERSION":
+			o.sinkTLS.minVersion = v
+		case "CEW_SINK_TOKEN_FILE":
+			o.sinkAuth.tokenFile = v
+		case "CEW_SINK_OAUTH2_TOKEN_URL":
+			o.sinkAuth.tokenURL = v
+		case "CEW_SINK_OAUTH2_CLIENT_ID":
+			o.sinkAuth.clientID = v
+		case "CEW_SINK_OAUTH2_CLIENT_SECRET":
+			o.sinkAuth.clientSecret = v
+		case "CEW_SINK_OAUTH2_CLIENT_SECRET_FILE":
+			o.sinkAuth.clientSecretFile = v
+		case "CEW_SINK_OAUTH2_SCOPES":
+			o.sinkAuth.scopes = v
+		case "CEW_SINK_USERNAME":
+			o.sinkAuth.username = v
+		case "CEW_SINK_PASSWORD":
+			o.sinkAuth.password = v
+		case "CEW_SINK_PASSWORD_FILE":
+			o.sinkAuth.passwordFile = v
+		case "CEW_DOWNSTREAM_CA_FILE":
+			o.downstreamTLS.caFile = v
+		case "CEW_DOWNSTREAM_CERT_FILE":
+			o.downstreamTLS.certFile = v
+		case "CEW_DOWNSTREAM_KEY_FILE":
+			o.downstreamTLS.keyFile = v
+		case "CEW_DOWNSTREAM_TLS_MIN_VERSION":
+			o.downstreamTLS.minVersion = v
+		case "CEW_DOWNSTREAM_SERVER_NAME":
+			o.downstreamTLS.serverName = v
+		case "CEW_DOWNSTREAM_INSECURE_SKIP_VERIFY":
+			o.downstreamTLS.insecureSkipVerify = v
+		case "CEW_DOWNSTREAM_MAX_IDLE_CONNS_PER_HOST":
+			o.downstreamMaxIdleConnsPerHost = v
+		case "CEW_DOWNSTREAM_IDLE_CONN_TIMEOUT":
+			o.downstreamIdleConnTimeout = v
+		case "CEW_DOWNSTREAM_HTTP2":
+			o.downstreamHTTP2 = v
 		case "PORT":
 			o.port = v
 		case "CEW_DOWNSTREAM":
@@ -106,6 +311,94 @@ func (o *options) getEnv(env []string) error {
 			o.logFormat = v
 		case "CEW_LOG_LEVEL":
 			o.logLevel = v
+		case "CEW_REQUEST_ID_HEADER":
+			o.requestIDHeader = v
+		case "CEW_LOG_SAMPLING":
+			o.logSampling = v
+		case "CEW_ADMIN_PORT":
+			o.adminPort = v
+		case "CEW_HTTPS_PORT":
+			o.httpsPort = v
+		case "CEW_MAX_IN_FLIGHT":
+			o.maxInFlight = v
+		case "CEW_RATE_LIMIT":
+			o.rateLimit.Rate = v
+		case "CEW_RATE_LIMIT_BURST":
+			o.rateLimit.Burst = v
+		case "CEW_RATE_LIMIT_BY":
+			o.rateLimit.By = v
+		case "CEW_RATE_LIMIT_HEADER":
+			o.rateLimit.Header = v
+		case "CEW_RATE_LIMITED_EVENT":
+			o.rateLimitedEvent = v
+		case "CEW_MAX_BODY_SIZE":
+			o.maxBodySize = v
+		case "CEW_CONTENT_TYPES":
+			o.contentTypes = v
+		case "CEW_DENY_PATHS":
+			o.denyPaths = v
+		case "CEW_ALLOW_PATHS":
+			o.allowPaths = v
+		case "CEW_ACCESS_DEFAULT":
+			o.accessDefault = v
+		case "CEW_BLOCKED_STATUS":
+			o.blockedStatus = v
+		case "CEW_BLOCKED_EVENT":
+			o.blockedEvent = v
+		case "CEW_CORS_ALLOWED_ORIGINS":
+			o.corsOrigins = v
+		case "CEW_CORS_ALLOWED_METHODS":
+			o.corsMethods = v
+		case "CEW_CORS_ALLOWED_HEADERS":
+			o.corsHeaders = v
+		case "CEW_CORS_EXPOSED_HEADERS":
+			o.corsExposedHeaders = v
+		case "CEW_CORS_ALLOW_CREDENTIALS":
+			o.corsCredentials = v
+		case "CEW_CORS_MAX_AGE":
+			o.corsMaxAge = v
+		case "CEW_JWT_MODE":
+			o.jwtMode = v
+		case "CEW_JWT_JWKS_FILE":
+			o.jwtJWKSFile = v
+		case "CEW_JWT_JWKS_URL":
+			o.jwtJWKSURL = v
+		case "CEW_JWT_ISSUER":
+			o.jwtIssuer = v
+		case "CEW_JWT_AUDIENCE":
+			o.jwtAudience = v
+		case "CEW_JWT_OPTIONAL":
+			o.jwtOptional = v
+		case "CEW_TLS_CERT_FILE":
+			o.tls.certFile = v
+		case "CEW_TLS_KEY_FILE":
+			o.tls.keyFile = v
+		case "CEW_TLS_CLIENT_CA_FILE":
+			o.tls.caFile = v
+		case "CEW_TLS_CLIENT_AUTH":
+			o.tls.clientAuth = v
+		case "CEW_TLS_MIN_VERSION":
+			o.tls.minVersion = v
+		case "CEW_ACCESS_LOG":
+			o.accessLog = v
+		case "CEW_ACCESS_LOG_FORMAT":
+			o.accessLogFormat = v
+		case "CEW_ACCESS_LOG_MAX_SIZE":
+			o.accessLogMaxSize = v
+		case "CEW_ACCESS_LOG_MAX_BACKUPS":
+			o.accessLogMaxBackups = v
+		case "CEW_TRACE_EXPORTER":
+			o.traceExporter = v
+		case "CEW_FILTER":
+			o.filter = v
+		case "CEW_REDACTION_SALT":
+			o.redactionSalt = v
+		case "CEW_DATA_MODE":
+			o.dataMode = v
+		case "CEW_DATA_MAX_REQUEST_SIZE":
+			o.dataMaxRequestSize = v
+		case "CEW_DATA_MAX_RESPONSE_SIZE":
+			o.dataMaxResponseSize = v
 		}
 	}
 	return nil
@@ -114,9 +407,63 @@ func (o *options) getEnv(env []string) error {
 func (o *options) parseArgs(args []string) error {
 	fs := flag.NewFlagSet("root", flag.ExitOnError)
 
+	configFile := fs.String("config", "", "configuration file in YAML or JSON format")
+	configPollInterval := fs.String("config-poll-interval", "", "interval for checking the config file for changes, e.g. 30s")
+	reloadEvent := fs.String("reload-event", "", "emit a config_reloaded event after a reload, true or false")
 	downstream := fs.String("downstream", "", "downstream service")
+	downstreamCAFile := fs.String("downstream-ca-file", "", "file with the CA certificates for the downstream service")
+	downstreamCertFile := fs.String("downstream-cert-file", "", "client certificate file for the downstream service")
+	downstreamKeyFile := fs.String("downstream-key-file", "", "client key file for the downstream service")
+	downstreamTLSMinVersion := fs.String("downstream-tls-min-version", "", "minimum TLS version for the downstream service, 1.2 or 1.3")
+	downstreamServerName := fs.String("downstream-server-name", "", "server name for SNI and verification of the downstream service")
+	downstreamInsecure := fs.String("downstream-insecure-skip-verify", "", "do not verify the certificate of the downstream service, only for development")
+	downstreamMaxIdle := fs.String("downstream-max-idle-conns-per-host", "", "max idle connections to the downstream service")
+	downstreamIdleTimeout := fs.String("downstream-idle-conn-timeout", "", "timeout for idle connections to the downstream service, e.g. 90s")
+	downstreamHTTP2 := fs.String("downstream-http2", "", "use HTTP/2 for the downstream service, true or false")
 	port := fs.String("port", "", "port to listen on")
+	httpsPort := fs.String("https-port", "", "port to listen on with TLS next to plain HTTP on port")
+	tlsCertFile := fs.String("tls-cert-file", "", "certificate file of the listener, enables TLS")
+	tlsKeyFile := fs.String("tls-key-file", "", "key file of the listener")
+	tlsClientCAFile := fs.String("tls-client-ca-file", "", "file with the CA certificates for the client certificates")
+	tlsClientAuth := fs.String("tls-client-auth", "", "client certificate verification, none, request or require")
+	tlsMinVersion := fs.String("tls-min-version", "", "minimum TLS version of the listener, 1.2 or 1.3")
+	maxInFlight := fs.String("max-in-flight", "", "max number of requests handled at the same time")
+	rateLimit := fs.String("rate-limit", "", "max number of requests per second")
+	rateLimitBurst := fs.String("rate-limit-burst", "", "number of requests above the rate that are allowed in a burst")
+	rateLimitBy := fs.String("rate-limit-by", "", "count the requests by route, clientIP or header")
+	rateLimitHeader := fs.String("rate-limit-header", "", "header to count the requests by")
+	rateLimitedEvent := fs.String("rate-limited-event", "", "emit a rate_limited event for the rejected requests, true or false")
+	maxBodySize := fs.String("max-body-size", "", "max size in bytes of the request bodies")
+	contentTypes := fs.String("content-types", "", "comma separated media types accepted for the request bodies")
+	denyPaths := fs.String("deny-paths", "", "comma separated path patterns that are blocked")
+	allowPaths := fs.String("allow-paths", "", "comma separated path patterns that are exposed, the other paths are blocked")
+	accessDefault := fs.String("access-default", "", "action for the paths that match no rule, allow or deny")
+	blockedStatus := fs.String("blocked-status", "", "status for the blocked requests, 404 or 403")
+	blockedEvent := fs.String("blocked-event", "", "emit a request_blocked event for the blocked requests, true or false")
+	corsOrigins := fs.String("cors-allowed-origins", "", "comma separated origins allowed to call, a * matches any text, enables CORS")
+	corsMethods := fs.String("cors-allowed-methods", "", "comma separated methods the origins may use")
+	corsHeaders := fs.String("cors-allowed-headers", "", "comma separated request headers the origins may send, * for all")
+	corsExposedHeaders := fs.String("cors-exposed-headers", "", "comma separated response headers the browsers make available")
+	corsCredentials := fs.String("cors-allow-credentials", "", "allow requests with credentials, true or false")
+	corsMaxAge := fs.String("cors-max-age", "", "duration the browsers cache a preflight")
+	jwtMode := fs.String("jwt-mode", "", "check of the bearer JWTs, validate or extract")
+	jwtJWKSFile := fs.String("jwt-jwks-file", "", "file with the key set that verifies the JWTs")
+	jwtJWKSURL := fs.String("jwt-jwks-url", "", "url of the key set that verifies the JWTs")
+	jwtIssuer := fs.String("jwt-issuer", "", "required issuer of the JWTs")
+	jwtAudience := fs.String("jwt-audience", "", "required audience of the JWTs")
+	jwtOptional := fs.String("jwt-optional", "", "pass the requests without a token, true or false")
 	sink := fs.String("sink", "", "url of the event sink")
+	sinkCAFile := fs.String("sink-ca-file", "", "file with the CA certificates for the sinks")
+	sinkCertFile := fs.String("sink-cert-file", "", "client certificate file for the sinks")
+	sinkKeyFile := fs.String("sink-key-file", "", "client key file for the sinks")
+	sinkTLSMinVersion := fs.String("sink-tls-min-version", "", "minimum TLS version for the sinks, 1.2 or 1.3")
+	sinkTokenFile := fs.String("sink-token-file", "", "file with the bearer token for the sinks")
+	sinkTokenURL := fs.String("sink-oauth2-token-url", "", "token url of the oauth2 client credentials flow for the sinks")
+	sinkClientID := fs.String("sink-oauth2-client-id", "", "client id of the oauth2 client credentials flow for the sinks")
+	sinkClientSecretFile := fs.String("sink-oauth2-client-secret-file", "", "file with the client secret of the oauth2 client credentials flow")
+	sinkScopes := fs.String("sink-oauth2-scopes", "", "scopes of the oauth2 client credentials flow, separated by a comma")
+	sinkUsername := fs.String("sink-username", "", "user name for basic auth to the sinks")
+	sinkPasswordFile := fs.String("sink-password-file", "", "file with the password for basic auth to the sinks")
 	typePrefix := fs.String("type", "", "type prefix")
 	dataschema := fs.String("dataschema", "", "dataschema")
 	pathPrefix := fs.String("path-prefix", "", "path prefix is removed from the subject")
@@ -124,17 +471,129 @@ func (o *options) parseArgs(args []string) error {
 	extraMethods := fs.String("extra-methods", "", "additional methods to trigger an event on, do not use together with change-methods")
 	logFormat := fs.String("log-format", "", "log format, json or text")
 	logLevel := fs.String("log-level", "", "log level, debug, info, warn, error")
+	requestIDHeader := fs.String("request-id-header", "", "header that carries the request id, defaults to X-Request-ID")
+	dataMode := fs.String("data-mode", "", "event data, response, request, both or auto")
+	filter := fs.String("filter", "", "CloudEvents SQL expression, only the events that match are sent")
+	logSampling := fs.String("log-sampling", "", "log the per request records of one in n requests")
+	adminPort := fs.String("admin-port", "", "port for the admin endpoints, disabled when not set")
+	accessLog := fs.String("access-log", "", "access log destination, stdout, stderr or a file name")
+	accessLogFormat := fs.String("access-log-format", "", "access log format, combined or json")
+	accessLogMaxSize := fs.String("access-log-max-size", "", "max size in MB of the access log file before rotation")
+	accessLogMaxBackups := fs.String("access-log-max-backups", "", "max number of rotated access log files")
+	traceExporter := fs.String("trace-exporter", "", "trace exporter, none, otlp or stdout")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	// copy the set vars to options.
+	if *configFile != "" {
+		o.configFile = *configFile
+	}
+	if *configPollInterval != "" {
+		o.configPollInterval = *configPollInterval
+	}
+	if *reloadEvent != "" {
+		o.reloadEvent = *reloadEvent
+	}
 	if *downstream != "" {
 		o.downstream = *downstream
 	}
 	if *port != "" {
 		o.port = *port
 	}
+	if *httpsPort != "" {
+		o.httpsPort = *httpsPort
+	}
+	if *tlsCertFile != "" {
+		o.tls.certFile = *tlsCertFile
+	}
+	if *tlsKeyFile != "" {
+		o.tls.keyFile = *tlsKeyFile
+	}
+	if *tlsClientCAFile != "" {
+		o.tls.caFile = *tlsClientCAFile
+	}
+	if *tlsClientAuth != "" {
+		o.tls.clientAuth = *tlsClientAuth
+	}
+	if *tlsMinVersion != "" {
+		o.tls.minVersion = *tlsMinVersion
+	}
+	if *maxInFlight != "" {
+		o.maxInFlight = *maxInFlight
+	}
+	if *rateLimit != "" {
+		o.rateLimit.Rate = *rateLimit
+	}
+	if *rateLimitBurst != "" {
+		o.rateLimit.Burst = *rateLimitBurst
+	}
+	if *rateLimitBy != "" {
+		o.rateLimit.By = *rateLimitBy
+	}
+	if *rateLimitHeader != "" {
+		o.rateLimit.Header = *rateLimitHeader
+	}
+	if *rateLimitedEvent != "" {
+		o.rateLimitedEvent = *rateLimitedEvent
+	}
+	if *maxBodySize != "" {
+		o.maxBodySize = *maxBodySize
+	}
+	if *contentTypes != "" {
+		o.contentTypes = *contentTypes
+	}
+	if *denyPaths != "" {
+		o.denyPaths = *denyPaths
+	}
+	if *allowPaths != "" {
+		o.allowPaths = *allowPaths
+	}
+	if *accessDefault != "" {
+		o.accessDefault = *accessDefault
+	}
+	if *blockedStatus != "" {
+		o.blockedStatus = *blockedStatus
+	}
+	if *blockedEvent != "" {
+		o.blockedEvent = *blockedEvent
+	}
+	if *corsOrigins != "" {
+		o.corsOrigins = *corsOrigins
+	}
+	if *corsMethods != "" {
+		o.corsMethods = *corsMethods
+	}
+	if *corsHeaders != "" {
+		o.corsHeaders = *corsHeaders
+	}
+	if *corsExposedHeaders != "" {
+		o.corsExposedHeaders = *corsExposedHeaders
+	}
+	if *corsCredentials != "" {
+		o.corsCredentials = *corsCredentials
+	}
+	if *corsMaxAge != "" {
+		o.corsMaxAge = *corsMaxAge
+	}
+	if *jwtMode != "" {
+		o.jwtMode = *jwtMode
+	}
+	if *jwtJWKSFile != "" {
+		o.jwtJWKSFile = *jwtJWKSFile
+	}
+	if *jwtJWKSURL != "" {
+		o.jwtJWKSURL = *jwtJWKSURL
+	}
+	if *jwtIssuer != "" {
+		o.jwtIssuer = *jwtIssuer
+	}
+	if *jwtAudience != "" {
+		o.jwtAudience = *jwtAudience
+	}
+	if *jwtOptional != "" {
+		o.jwtOptional = *jwtOptional
+	}
 	if *sink != "" {
 		o.sink = *sink
 	}
@@ -159,37 +618,758 @@ func (o *options) parseArgs(args []string) error {
 	if *logLevel != "" {
 		o.logLevel = *logLevel
 	}
+	if *requestIDHeader != "" {
+		o.requestIDHeader = *requestIDHeader
+	}
+	if *logSampling != "" {
+		o.logSampling = *logSampling
+	}
+	if *adminPort != "" {
+		o.adminPort = *adminPort
+	}
+	if *accessLog != "" {
+		o.accessLog = *accessLog
+	}
+	if *accessLogFormat != "" {
+		o.accessLogFormat = *accessLogFormat
+	}
+	if *accessLogMaxSize != "" {
+		o.accessLogMaxSize = *accessLogMaxSize
+	}
+	if *accessLogMaxBackups != "" {
+		o.accessLogMaxBackups = *accessLogMaxBackups
+	}
+	if *traceExporter != "" {
+		o.traceExporter = *traceExporter
+	}
+	if *filter != "" {
+		o.filter = *filter
+	}
+	if *downstreamCAFile != "" {
+		o.downstreamTLS.caFile = *downstreamCAFile
+	}
+	if *downstreamCertFile != "" {
+		o.downstreamTLS.certFile = *downstreamCertFile
+	}
+	if *downstreamKeyFile != "" {
+		o.downstreamTLS.keyFile = *downstreamKeyFile
+	}
+	if *downstreamTLSMinVersion != "" {
+		o.downstreamTLS.minVersion = *downstreamTLSMinVersion
+	}
+	if *downstreamServerName != "" {
+		o.downstreamTLS.serverName = *downstreamServerName
+	}
+	if *downstreamInsecure != "" {
+		o.downstreamTLS.insecureSkipVerify = *downstreamInsecure
+	}
+	if *downstreamMaxIdle != "" {
+		o.downstreamMaxIdleConnsPerHost = *downstreamMaxIdle
+	}
+	if *downstreamIdleTimeout != "" {
+		o.downstreamIdleConnTimeout = *downstreamIdleTimeout
+	}
+	if *downstreamHTTP2 != "" {
+		o.downstreamHTTP2 = *downstreamHTTP2
+	}
+	if *sinkCAFile != "" {
+		o.sinkTLS.caFile = *sinkCAFile
+	}
+	if *sinkCertFile != "" {
+		o.sinkTLS.certFile = *sinkCertFile
+	}
+	if *sinkKeyFile != "" {
+		o.sinkTLS.keyFile = *sinkKeyFile
+	}
+	if *sinkTLSMinVersion != "" {
+		o.sinkTLS.minVersion = *sinkTLSMinVersion
+	}
+	if *sinkTokenFile != "" {
+		o.sinkAuth.tokenFile = *sinkTokenFile
+	}
+	if *sinkTokenURL != "" {
+		o.sinkAuth.tokenURL = *sinkTokenURL
+	}
+	if *sinkClientID != "" {
+		o.sinkAuth.clientID = *sinkClientID
+	}
+	if *sinkClientSecretFile != "" {
+		o.sinkAuth.clientSecretFile = *sinkClientSecretFile
+	}
+	if *sinkScopes != "" {
+		o.sinkAuth.scopes = *sinkScopes
+	}
+	if *sinkUsername != "" {
+		o.sinkAuth.username = *sinkUsername
+	}
+	if *sinkPasswordFile != "" {
+		o.sinkAuth.passwordFile = *sinkPasswordFile
+	}
+	if *dataMode != "" {
+		o.dataMode = *dataMode
+	}
+
+	return nil
+}
+
+// fieldError qualifies err with the path of the field in the config file.
+func fieldError(path string, err error) error {
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+// validateURL checks that v is an absolute url.
+func validateURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute url", v)
+	}
+	return nil
+}
+
+// validatePort checks that v is a valid port number.
+func validatePort(v string) error {
+	if _, err := strconv.ParseUint(v, 10, 16); err != nil {
+		return fmt.Errorf("%q is not a valid port number", v)
+	}
+	return nil
+}
+
+// validateCount checks that v is a positive number.
+func validateCount(v string, bitSize int) error {
+	if _, err := strconv.ParseUint(v, 10, bitSize); err != nil {
+		return fmt.Errorf("%q is not a positive number", v)
+	}
+	return nil
+}
+
+// validateListenerTLS checks the TLS settings of the listener and the https port.
+func (o *options) validateListenerTLS() []error {
+	errs := o.tls.validate("tls")
+	if o.tls.isSet() && o.tls.certFile == "" && o.tls.keyFile == "" {
+		errs = append(errs, fieldError("tls.certFile", errors.New("not set, needed for TLS")))
+	}
+	if o.tls.serverName != "" || o.tls.insecureSkipVerify != "" {
+		errs = append(errs, fieldError("tls", errors.New("serverName and insecureSkipVerify are only used for client connections")))
+	}
+	if (o.tls.clientAuth == "request" || o.tls.clientAuth == "require") && o.tls.caCerts == "" && o.tls.caFile == "" {
+		errs = append(errs, fieldError("tls.clientAuth", errors.New("needs caFile or caCerts")))
+	}
+	if o.sinkTLS.clientAuth != "" {
+		errs = append(errs, fieldError("sinkTLS.clientAuth", errors.New("only used for the listener")))
+	}
+	if o.downstreamTLS.clientAuth != "" {
+		errs = append(errs, fieldError("downstreamTLS.clientAuth", errors.New("only used for the listener")))
+	}
+	if o.httpsPort != "" {
+		if err := validatePort(o.httpsPort); err != nil {
+			errs = append(errs, fieldError("httpsPort", err))
+		}
+		if o.httpsPort == o.port || o.port == "" && o.httpsPort == "8080" || o.httpsPort == o.adminPort {
+			errs = append(errs, fieldError("httpsPort", errors.New("must differ from port and adminPort")))
+		}
+		if o.tls.certFile == "" {
+			errs = append(errs, fieldError("httpsPort", errors.New("needs tls.certFile")))
+		}
+		// The plain port would serve the same handler without the client certificates.
+		if o.tls.serverClientAuth() == "require" {
+			errs = append(errs, fieldError("httpsPort", errors.New("cannot be used with tls.clientAuth require, the default with a CA")))
+		}
+	}
+	return errs
+}
+
+// validateJWT checks the settings of the bearer JWT check.
+func (o *options) validateJWT() []error {
+	var errs []error
+	switch o.jwtMode {
+	case "":
+		if o.jwtJWKSFile != "" || o.jwtJWKSURL != "" || o.jwtIssuer != "" || o.jwtAudience != "" || o.jwtOptional != "" {
+			errs = append(errs, fieldError("jwt.mode", errors.New("not set, needed with the other jwt settings")))
+		}
+	case "validate":
+		switch {
+		case o.jwtJWKSFile == "" && o.jwtJWKSURL == "":
+			errs = append(errs, fieldError("jwt.jwksFile", errors.New("not set, needed for mode validate")))
+		case o.jwtJWKSFile != "" && o.jwtJWKSURL != "":
+			errs = append(errs, fieldError("jwt", errors.New("set only one of jwksFile and jwksURL")))
+		case o.jwtJWKSFile != "":
+			if err := (&cewrap.JWKS{File: o.jwtJWKSFile}).Load(); err != nil {
+				errs = append(errs, fieldError("jwt.jwksFile", err))
+			}
+		default:
+			if err := validateURL(o.jwtJWKSURL); err != nil {
+				errs = append(errs, fieldError("jwt.jwksURL", err))
+			}
+		}
+	case "extract":
+		if o.jwtJWKSFile != "" || o.jwtJWKSURL != "" || o.jwtIssuer != "" || o.jwtAudience != "" {
+			errs = append(errs, fieldError("jwt", errors.New("the key set, issuer and audience are only used for mode validate")))
+		}
+	default:
+		errs = append(errs, fieldError("jwt.mode", fmt.Errorf("unknown mode %q, use validate or extract", o.jwtMode)))
+	}
+	if o.jwtOptional != "" {
+		if _, err := strconv.ParseBool(o.jwtOptional); err != nil {
+			errs = append(errs, fieldError("jwt.optional", fmt.Errorf("%q is not a boolean", o.jwtOptional)))
+		}
+	}
+	return errs
+}
+
+// getJWTAuth returns the bearer JWT check, nil when it is not set.
+func (o *options) getJWTAuth() *cewrap.JWTAuth {
+	if o.jwtMode == "" {
+		return nil
+	}
+	a := &cewrap.JWTAuth{
+		Issuer:   o.jwtIssuer,
+		Audience: o.jwtAudience,
+	}
+	a.Optional, _ = strconv.ParseBool(o.jwtOptional)
+	if o.jwtMode == "validate" {
+		a.Keys = &cewrap.JWKS{File: o.jwtJWKSFile, URL: o.jwtJWKSURL}
+	}
+	return a
+}
+
+// validateSinks checks the extra sinks.
+func (o *options) validateSinks() []error {
+	var errs []error
+	names := map[string]bool{}
+	for i, sc := range o.sinks {
+		path := fmt.Sprintf("sinks[%d]", i)
+		if sc.Name != "" {
+			if names[sc.Name] {
+				errs = append(errs, fieldError(path+".name", fmt.Errorf("duplicate sink name %q", sc.Name)))
+			}
+			names[sc.Name] = true
+		}
+		if sc.URL == "" {
+			errs = append(errs, fieldError(path+".url", errors.New("not set")))
+		} else if err := validateURL(sc.URL); err != nil {
+			errs = append(errs, fieldError(path+".url", err))
+		}
+		if sc.Timeout != "" {
+			if err := validateDuration(sc.Timeout); err != nil {
+				errs = append(errs, fieldError(path+".timeout", err))
+			}
+		}
+		if sc.RetryDelay != "" {
+			if err := validateDuration(sc.RetryDelay); err != nil {
+				errs = append(errs, fieldError(path+".retryDelay", err))
+			}
+		}
+		if sc.Retries < 0 {
+			errs = append(errs, fieldError(path+".retries", fmt.Errorf("%d is negative", sc.Retries)))
+		}
+		if sc.Filter.SQL != "" {
+			if _, err := cewrap.CESQLFilter(sc.Filter.SQL); err != nil {
+				errs = append(errs, fieldError(path+".filter.sql", err))
+			}
+		}
+	}
+	return errs
+}
+
+// validateFieldPath checks that p is a dot separated list of field names.
+func validateFieldPath(p string) error {
+	for _, name := range strings.Split(p, ".") {
+		if name == "" {
+			return fmt.Errorf("%q is not a valid field path", p)
+		}
+	}
+	return nil
+}
+
+// validateTransform checks the transformation at path.
+func validateTransform(path string, tc *transformConfig) []error {
+	var errs []error
+	check := func(field, p string) {
+		if err := validateFieldPath(p); err != nil {
+			errs = append(errs, fieldError(path+"."+field, err))
+		}
+	}
+	for i, p := range tc.Include {
+		check(fmt.Sprintf("include[%d]", i), p)
+	}
+	for i, p := range tc.Exclude {
+		check(fmt.Sprintf("exclude[%d]", i), p)
+	}
+	for i, r := range tc.Rename {
+		check(fmt.Sprintf("rename[%d].from", i), r.From)
+		check(fmt.Sprintf("rename[%d].to", i), r.To)
+	}
+	return errs
+}
+
+// validateRoutes checks the transformation and the routes.
+func (o *options) validateRoutes() []error {
+	var errs []error
+	if o.transform != nil {
+		errs = append(errs, validateTransform("transform", o.transform)...)
+	}
+	for i, rc := range o.routes {
+		path := fmt.Sprintf("routes[%d]", i)
+		if len(rc.Paths) == 0 {
+			errs = append(errs, fieldError(path+".paths", errors.New("not set")))
+		}
+		for j, p := range rc.Paths {
+			if !strings.HasPrefix(p, "/") {
+				errs = append(errs, fieldError(fmt.Sprintf("%s.paths[%d]", path, j), fmt.Errorf("%q does not start with /", p)))
+			}
+		}
+		if rc.Transform != nil {
+			errs = append(errs, validateTransform(path+".transform", rc.Transform)...)
+		}
+		if rc.RateLimit != nil {
+			errs = append(errs, rc.RateLimit.validate(path+".rateLimit")...)
+		}
+		if rc.MaxBodySize != "" {
+			if err := validateCount(rc.MaxBodySize, 63); err != nil {
+				errs = append(errs, fieldError(path+".maxBodySize", err))
+			}
+		}
+	}
+	return errs
+}
+
+// validateLimits checks the concurrency limit and the default rate limit.
+func (o *options) validateLimits() []error {
+	var errs []error
+	if o.maxInFlight != "" {
+		if err := validateCount(o.maxInFlight, 31); err != nil {
+			errs = append(errs, fieldError("limits.maxInFlight", err))
+		}
+	}
+	if o.rateLimit != (rateLimitConfig{}) {
+		errs = append(errs, o.rateLimit.validate("limits.rateLimit")...)
+	}
+	if o.rateLimitedEvent != "" {
+		if _, err := strconv.ParseBool(o.rateLimitedEvent); err != nil {
+			errs = append(errs, fieldError("limits.event", fmt.Errorf("%q is not a boolean", o.rateLimitedEvent)))
+		}
+	}
+	if o.maxBodySize != "" {
+		if err := validateCount(o.maxBodySize, 63); err != nil {
+			errs = append(errs, fieldError("limits.maxBodySize", err))
+		}
+	}
+	for i, ct := range splitList(o.contentTypes) {
+		if t, sub, ok := strings.Cut(ct, "/"); !ok || t == "" || sub == "" || strings.ContainsAny(ct, " ;") {
+			errs = append(errs, fieldError(fmt.Sprintf("limits.contentTypes[%d]", i), fmt.Errorf("%q is not a media type", ct)))
+		}
+	}
+	return errs
+}
+
+// getBodyLimits returns the limits for the request bodies, nil when none are set.
+func (o *options) getBodyLimits() *cewrap.BodyLimits {
+	b := &cewrap.BodyLimits{ContentTypes: splitList(o.contentTypes)}
+	b.MaxBytes, _ = strconv.ParseInt(o.maxBodySize, 10, 64)
+	for _, rc := range o.routes {
+		if rc.MaxBodySize != "" {
+			max, _ := strconv.ParseInt(rc.MaxBodySize, 10, 64)
+			b.Routes = append(b.Routes, cewrap.RouteBodyLimit{Paths: rc.Paths, MaxBytes: max})
+		}
+	}
+	if b.MaxBytes == 0 && len(b.Routes) == 0 && len(b.ContentTypes) == 0 {
+		return nil
+	}
+	return b
+}
+
+// limitState holds the buckets and the requests in flight of all sources,
+// so a reload gives the clients no new burst.
+var limitState = &cewrap.LimitState{}
+
+// getLimits returns the limits, nil when none are set.
+// The route rate limits come before the default one, the first match applies.
+func (o *options) getLimits() *cewrap.Limits {
+	l := &cewrap.Limits{Metrics: limitMetrics, State: limitState}
+	l.MaxInFlight, _ = strconv.Atoi(o.maxInFlight)
+	l.Event, _ = strconv.ParseBool(o.rateLimitedEvent)
+	for i, rc := range o.routes {
+		if rc.RateLimit != nil {
+			l.RateLimits = append(l.RateLimits, rc.RateLimit.rateLimit(fmt.Sprintf("routes[%d]", i), rc.Paths))
+		}
+	}
+	if o.rateLimit != (rateLimitConfig{}) {
+		l.RateLimits = append(l.RateLimits, o.rateLimit.rateLimit("default", nil))
+	}
+	if l.MaxInFlight == 0 && len(l.RateLimits) == 0 {
+		return nil
+	}
+	return l
+}
 
+// validateAccess checks the access rules.
+func (o *options) validateAccess() []error {
+	var errs []error
+	checkPaths := func(path string, paths []string) {
+		for j, p := range paths {
+			if !strings.HasPrefix(p, "/") {
+				errs = append(errs, fieldError(fmt.Sprintf("%s[%d]", path, j), fmt.Errorf("%q does not start with /", p)))
+			}
+		}
+	}
+	checkPaths("access.denyPaths", splitList(o.denyPaths))
+	checkPaths("access.allowPaths", splitList(o.allowPaths))
+	for i, rc := range o.accessRules {
+		path := fmt.Sprintf("access.rules[%d]", i)
+		if len(rc.Paths) == 0 {
+			errs = append(errs, fieldError(path+".paths", errors.New("not set")))
+		}
+		checkPaths(path+".paths", rc.Paths)
+		for j, m := range rc.Methods {
+			if m == "" || strings.ContainsAny(m, " \t") {
+				errs = append(errs, fieldError(fmt.Sprintf("%s.methods[%d]", path, j), fmt.Errorf("%q is not a valid method", m)))
+			}
+		}
+		if rc.Action != string(cewrap.AccessAllow) && rc.Action != string(cewrap.AccessDeny) {
+			errs = append(errs, fieldError(path+".action", fmt.Errorf("%q is not allow or deny", rc.Action)))
+		}
+	}
+	switch o.accessDefault {
+	case "", string(cewrap.AccessAllow), string(cewrap.AccessDeny):
+	default:
+		errs = append(errs, fieldError("access.default", fmt.Errorf("%q is not allow or deny", o.accessDefault)))
+	}
+	switch o.blockedStatus {
+	case "", "404", "403":
+	default:
+		errs = append(errs, fieldError("access.status", fmt.Errorf("%q is not 404 or 403", o.blockedStatus)))
+	}
+	if o.blockedEvent != "" {
+		if _, err := strconv.ParseBool(o.blockedEvent); err != nil {
+			errs = append(errs, fieldError("access.event", fmt.Errorf("%q is not a boolean", o.blockedEvent)))
+		}
+	}
+	return errs
+}
+
+// getAccess returns the access control, nil when no rules are set.
+// The deny paths come first, then the rules and then the allow paths,
+// the first match applies.
+func (o *options) getAccess() *cewrap.AccessControl {
+	a := &cewrap.AccessControl{Default: cewrap.AccessAction(o.accessDefault)}
+	if p := splitList(o.denyPaths); len(p) > 0 {
+		a.Rules = append(a.Rules, cewrap.AccessRule{Paths: p, Action: cewrap.AccessDeny})
+	}
+	for _, rc := range o.accessRules {
+		a.Rules = append(a.Rules, cewrap.AccessRule{Paths: rc.Paths, Methods: rc.Methods, Action: cewrap.AccessAction(rc.Action)})
+	}
+	if p := splitList(o.allowPaths); len(p) > 0 {
+		a.Rules = append(a.Rules, cewrap.AccessRule{Paths: p, Action: cewrap.AccessAllow})
+	}
+	if len(a.Rules) == 0 && a.Default != cewrap.AccessDeny {
+		return nil
+	}
+	a.Status, _ = strconv.Atoi(o.blockedStatus)
+	a.Event, _ = strconv.ParseBool(o.blockedEvent)
+	return a
+}
+
+// validateCORS checks the CORS settings.
+func (o *options) validateCORS() []error {
+	var errs []error
+	origins := splitList(o.corsOrigins)
+	if len(origins) == 0 {
+		if o.corsMethods != "" || o.corsHeaders != "" || o.corsExposedHeaders != "" || o.corsCredentials != "" || o.corsMaxAge != "" {
+			errs = append(errs, fieldError("cors.allowedOrigins", errors.New("not set, needed for the other cors settings")))
+		}
+		return errs
+	}
+	for i, origin := range origins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			errs = append(errs, fieldError(fmt.Sprintf("cors.allowedOrigins[%d]", i), fmt.Errorf("%q is not an http or https origin", origin)))
+		}
+	}
+	for i, m := range splitList(o.corsMethods) {
+		if strings.ContainsAny(m, " \t") {
+			errs = append(errs, fieldError(fmt.Sprintf("cors.allowedMethods[%d]", i), fmt.Errorf("%q is not a valid method", m)))
+		}
+	}
+	if o.corsCredentials != "" {
+		if creds, err := strconv.ParseBool(o.corsCredentials); err != nil {
+			errs = append(errs, fieldError("cors.allowCredentials", fmt.Errorf("%q is not a boolean", o.corsCredentials)))
+		} else if creds {
+			for i, origin := range origins {
+				if !cewrap.ExplicitOrigin(origin) {
+					errs = append(errs, fieldError(fmt.Sprintf("cors.allowedOrigins[%d]", i), fmt.Errorf("%q matches any site, not allowed with allowCredentials", origin)))
+				}
+			}
+		}
+	}
+	if o.corsMaxAge != "" {
+		if err := validateDuration(o.corsMaxAge); err != nil {
+			errs = append(errs, fieldError("cors.maxAge", err))
+		}
+	}
+	return errs
+}
+
+// getCORS returns the CORS settings, nil when no origins are set.
+func (o *options) getCORS() *cewrap.CORS {
+	origins := splitList(o.corsOrigins)
+	if len(origins) == 0 {
+		return nil
+	}
+	c := &cewrap.CORS{
+		AllowedOrigins: origins,
+		AllowedMethods: splitList(o.corsMethods),
+		AllowedHeaders: splitList(o.corsHeaders),
+		ExposedHeaders: splitList(o.corsExposedHeaders),
+	}
+	c.AllowCredentials, _ = strconv.ParseBool(o.corsCredentials)
+	c.MaxAge, _ = time.ParseDuration(o.corsMaxAge)
+	return c
+}
+
+// validateExtensions checks the extension mappings.
+func (o *options) validateExtensions() []error {
+	var errs []error
+	names := map[string]bool{}
+	for i, ec := range o.extensions {
+		path := fmt.Sprintf("extensions[%d]", i)
+		if err := cewrap.ValidateExtensionName(ec.Name); err != nil {
+			errs = append(errs, fieldError(path+".name", err))
+		} else if names[ec.Name] {
+			errs = append(errs, fieldError(path+".name", fmt.Errorf("duplicate extension %q", ec.Name)))
+		}
+		names[ec.Name] = true
+		switch cewrap.ExtensionFrom(ec.From) {
+		case cewrap.FromRequestHeader, cewrap.FromResponseHeader, cewrap.FromQuery, cewrap.FromClaim:
+			if ec.Key == "" {
+				errs = append(errs, fieldError(path+".key", errors.New("not set")))
+			}
+		case cewrap.FromPath:
+			if ec.Key == "" {
+				errs = append(errs, fieldError(path+".key", errors.New("not set")))
+			} else if !strings.Contains(ec.Pattern, "{"+ec.Key+"}") {
+				errs = append(errs, fieldError(path+".pattern", fmt.Errorf("%q has no {%s} segment", ec.Pattern, ec.Key)))
+			}
+		case cewrap.FromClientCert:
+			if ec.Key != "subject" && ec.Key != "san" {
+				errs = append(errs, fieldError(path+".key", fmt.Errorf("%q is not subject or san", ec.Key)))
+			}
+		case cewrap.FromStatus:
+		default:
+			errs = append(errs, fieldError(path+".from", fmt.Errorf("unknown source %q", ec.From)))
+		}
+	}
+	return errs
+}
+
+// validateRedaction checks the redaction rules.
+func (o *options) validateRedaction() []error {
+	var errs []error
+	hash := false
+	for i, rc := range o.redactRules {
+		path := fmt.Sprintf("redaction.rules[%d]", i)
+		switch cewrap.RedactAction(rc.Action) {
+		case "", cewrap.RedactMask, cewrap.RedactRemove:
+		case cewrap.RedactHash:
+			hash = true
+		default:
+			errs = append(errs, fieldError(path+".action", fmt.Errorf("unknown action %q", rc.Action)))
+		}
+		if len(rc.Paths)+len(rc.Keys)+len(rc.Values)+len(rc.Headers) == 0 {
+			errs = append(errs, fieldError(path, errors.New("no paths, keys, values or headers set")))
+		}
+		for j, p := range rc.Paths {
+			if err := validateFieldPath(p); err != nil {
+				errs = append(errs, fieldError(fmt.Sprintf("%s.paths[%d]", path, j), err))
+			}
+		}
+		for j, v := range rc.Values {
+			if _, err := regexp.Compile(v); err != nil {
+				errs = append(errs, fieldError(fmt.Sprintf("%s.values[%d]", path, j), err))
+			}
+		}
+	}
+	if hash && o.redactionSalt == "" {
+		errs = append(errs, fieldError("redaction.salt", errors.New("not set, the hash action needs a salt")))
+	}
+	return errs
+}
+
+// validateDuration checks that v is a positive duration.
+func validateDuration(v string) error {
+	if d, err := time.ParseDuration(v); err != nil || d < 0 {
+		return fmt.Errorf("%q is not a valid duration", v)
+	}
 	return nil
 }
 
+// validate checks the options and sets the defaults.
+//
+// The errors are qualified with the path of the field in the config file.
 func (o *options) validate() error {
 	var errs []error
 
 	// Check the urls.
 	if o.downstream != "" {
-		if _, err := url.Parse(o.downstream); err != nil {
-			errs = append(errs, fmt.Errorf("error parsing downstream: %w", err))
+		if err := validateURL(o.downstream); err != nil {
+			errs = append(errs, fieldError("downstream", err))
 		}
 	} else {
-		errs = append(errs, errors.New("downstream not set"))
+		errs = append(errs, fieldError("downstream", errors.New("not set")))
 	}
 
 	if o.sink != "" {
-		if _, err := url.Parse(o.sink); err != nil {
-			errs = append(errs, fmt.Errorf("error parsing sink: %w", err))
+		if err := validateURL(o.sink); err != nil {
+			errs = append(errs, fieldError("sink", err))
+		}
+	} else if len(o.sinks) == 0 {
+		errs = append(errs, fieldError("sink", errors.New("not set")))
+	}
+	errs = append(errs, o.validateSinks()...)
+	errs = append(errs, o.sinkTLS.validate("sinkTLS")...)
+	errs = append(errs, o.sinkAuth.validate("sinkAuth")...)
+
+	// Check the downstream connection settings.
+	errs = append(errs, o.downstreamTLS.validate("downstreamTLS")...)
+	if o.downstreamMaxIdleConnsPerHost != "" {
+		if err := validateCount(o.downstreamMaxIdleConnsPerHost, 31); err != nil {
+			errs = append(errs, fieldError("downstreamTransport.maxIdleConnsPerHost", err))
+		}
+	}
+	if o.downstreamIdleConnTimeout != "" {
+		if err := validateDuration(o.downstreamIdleConnTimeout); err != nil {
+			errs = append(errs, fieldError("downstreamTransport.idleConnTimeout", err))
+		}
+	}
+	if o.downstreamHTTP2 != "" {
+		if _, err := strconv.ParseBool(o.downstreamHTTP2); err != nil {
+			errs = append(errs, fieldError("downstreamTransport.http2", fmt.Errorf("%q is not a boolean", o.downstreamHTTP2)))
+		}
+	}
+	errs = append(errs, o.validateRoutes()...)
+	errs = append(errs, o.validateRedaction()...)
+	errs = append(errs, o.validateExtensions()...)
+	if o.ceOverrides != "" {
+		if _, err := cewrap.ParseCEOverrides(o.ceOverrides); err != nil {
+			errs = append(errs, fieldError("K_CE_OVERRIDES", err))
 		}
-	} else {
-		errs = append(errs, errors.New("sink not set"))
 	}
 
 	// Check if port is set and numeric
 	if o.port != "" {
-		if _, err := strconv.Atoi(o.port); err != nil {
-			errs = append(errs, fmt.Errorf("port is not numeric: %w", err))
+		if err := validatePort(o.port); err != nil {
+			errs = append(errs, fieldError("port", err))
+		}
+	}
+
+	// Check if the admin port is numeric and differs from the port.
+	if o.adminPort != "" {
+		if err := validatePort(o.adminPort); err != nil {
+			errs = append(errs, fieldError("adminPort", err))
+		}
+		if o.adminPort == o.port || o.port == "" && o.adminPort == "8080" {
+			errs = append(errs, fieldError("adminPort", errors.New("must differ from port")))
+		}
+	}
+	errs = append(errs, o.validateListenerTLS()...)
+	errs = append(errs, o.validateJWT()...)
+	errs = append(errs, o.validateLimits()...)
+	errs = append(errs, o.validateAccess()...)
+	errs = append(errs, o.validateCORS()...)
+
+	// Check the reload settings.
+	if o.configPollInterval != "" {
+		if err := validateDuration(o.configPollInterval); err != nil {
+			errs = append(errs, fieldError("reload.pollInterval", err))
+		}
+	}
+	if o.reloadEvent != "" {
+		if b, err := strconv.ParseBool(o.reloadEvent); err != nil {
+			errs = append(errs, fieldError("reload.event", fmt.Errorf("%q is not a boolean", o.reloadEvent)))
+		} else {
+			o.reloadEvent = strconv.FormatBool(b)
+		}
+	}
+
+	// Check the event data settings.
+	switch cewrap.DataMode(o.dataMode) {
+	case "", cewrap.DataResponse, cewrap.DataRequest, cewrap.DataBoth, cewrap.DataAuto:
+	default:
+		errs = append(errs, fieldError("data.mode", fmt.Errorf("unknown data mode %q", o.dataMode)))
+	}
+	if o.dataMaxRequestSize != "" {
+		if err := validateCount(o.dataMaxRequestSize, 31); err != nil {
+			errs = append(errs, fieldError("data.maxRequestSize", err))
+		}
+	}
+	if o.dataMaxResponseSize != "" {
+		if err := validateCount(o.dataMaxResponseSize, 31); err != nil {
+			errs = append(errs, fieldError("data.maxResponseSize", err))
+		}
+	}
+
+	// Check the filter expression.
+	if o.filter != "" {
+		if _, err := cewrap.CESQLFilter(o.filter); err != nil {
+			errs = append(errs, fieldError("filter", err))
+		}
+	}
+
+	// Check the change methods.
+	for i, m := range o.changeMethods {
+		if m == "" || strings.ContainsAny(m, " \t") {
+			errs = append(errs, fieldError(fmt.Sprintf("changeMethods[%d]", i), fmt.Errorf("%q is not a valid method", m)))
+		} else if m == http.MethodOptions {
+			errs = append(errs, fieldError(fmt.Sprintf("changeMethods[%d]", i), errors.New("OPTIONS cannot be a change method")))
 		}
 	}
 
+	// Check the log settings.
+	switch o.logFormat {
+	case "", "text", "json":
+		break
+	default:
+		errs = append(errs, fieldError("log.format", fmt.Errorf("unknown log format %q", o.logFormat)))
+	}
+	switch o.logLevel {
+	case "", "debug", "info", "warn", "error":
+		break
+	default:
+		errs = append(errs, fieldError("log.level", fmt.Errorf("unknown log level %q", o.logLevel)))
+	}
+	if o.logSampling != "" {
+		if err := validateCount(o.logSampling, 64); err != nil {
+			errs = append(errs, fieldError("log.sampling", err))
+		}
+	}
+
+	// Check the access log settings.
+	switch cewrap.AccessLogFormat(o.accessLogFormat) {
+	case "", cewrap.AccessLogCombined, cewrap.AccessLogJSON:
+		break
+	default:
+		errs = append(errs, fieldError("accessLog.format", fmt.Errorf("unknown access log format %q", o.accessLogFormat)))
+	}
+	if o.accessLogMaxSize != "" {
+		if err := validateCount(o.accessLogMaxSize, 31); err != nil {
+			errs = append(errs, fieldError("accessLog.maxSize", err))
+		}
+	}
+	if o.accessLogMaxBackups != "" {
+		if err := validateCount(o.accessLogMaxBackups, 31); err != nil {
+			errs = append(errs, fieldError("accessLog.maxBackups", err))
+		}
+	}
+
+	// Check the trace exporter.
+	switch o.traceExporter {
+	case "", traceExporterNone, traceExporterOTLP, traceExporterStdout:
+		break
+	default:
+		errs = append(errs, fieldError("tracing.exporter", fmt.Errorf("unknown trace exporter %q", o.traceExporter)))
+	}
+
 	if !o.changeMethodsSet {
 		o.changeMethods = append(o.changeMethods, cewrap.DefaultChangeMethods...)
 	}
@@ -201,20 +1381,19 @@ func (o *options) validate() error {
 	if o.port == "" {
 		o.port = "8080"
 	}
-	// Check logformat
-	switch o.logFormat {
-	case "text", "json":
-		break
-	default:
+	// Set the log defaults.
+	if o.logFormat == "" {
 		o.logFormat = "text"
 	}
-	// Check logformat
-	switch o.logLevel {
-	case "debug", "info", "warn", "error":
-		break
-	default:
+	if o.logLevel == "" {
 		o.logLevel = "info"
 	}
+	if o.traceExporter == "" {
+		o.traceExporter = traceExporterNone
+	}
+	if o.accessLog != "" && o.accessLogFormat == "" {
+		o.accessLogFormat = string(cewrap.AccessLogCombined)
+	}
 
 	return nil
 }
@@ -223,10 +1402,107 @@ func (o *options) getSourceOptions() ([]cewrap.SourceOption, error) {
 	var so []cewrap.SourceOption
 
 	// create the sink
-	sink, err := client.NewHTTP(cloudevents.WithTarget(o.sink))
+	if o.sink != "" {
+		sink, err := o.newSinkClient(o.sink)
+		if err != nil {
+			return nil, err
+		}
+		so = append(so, cewrap.WithSink(sink))
+	}
+
+	// create the extra sinks
+	sinks, err := o.getSinks()
+	if err != nil {
+		return nil, err
+	}
+	if len(sinks) > 0 {
+		so = append(so, cewrap.WithSinks(sinks...))
+	}
+
+	if o.dataMode != "" || o.dataMaxRequestSize != "" || o.dataMaxResponseSize != "" || len(o.dataHeaders) > 0 {
+		d := cewrap.EventData{
+			Mode:    cewrap.DataMode(o.dataMode),
+			Headers: o.dataHeaders,
+		}
+		d.MaxRequestBytes, _ = strconv.Atoi(o.dataMaxRequestSize)
+		d.MaxResponseBytes, _ = strconv.Atoi(o.dataMaxResponseSize)
+		so = append(so, cewrap.WithEventData(d))
+	}
+
+	if r := o.getRedactor(); r != nil {
+		so = append(so, cewrap.WithRedactor(r))
+	}
+
+	if l := o.getLimits(); l != nil {
+		so = append(so, cewrap.WithLimits(l))
+	}
+
+	if b := o.getBodyLimits(); b != nil {
+		so = append(so, cewrap.WithBodyLimits(b))
+	}
+
+	if c := o.getCORS(); c != nil {
+		so = append(so, cewrap.WithCORS(c))
+	}
+
+	if a := o.getAccess(); a != nil {
+		so = append(so, cewrap.WithAccessControl(a))
+	}
+
+	if a := o.getJWTAuth(); a != nil {
+		so = append(so, cewrap.WithJWTAuth(a))
+	}
+
+	if len(o.extensions) > 0 {
+		var mappings []cewrap.ExtensionMapping
+		for _, ec := range o.extensions {
+			mappings = append(mappings, cewrap.ExtensionMapping{
+				Extension: ec.Name,
+				From:      cewrap.ExtensionFrom(ec.From),
+				Key:       ec.Key,
+				Pattern:   ec.Pattern,
+			})
+		}
+		m, err := cewrap.ExtensionMapper(mappings...)
+		if err != nil {
+			return nil, err
+		}
+		so = append(so, cewrap.WithEventMutators(m))
+	}
+
+	if o.ceOverrides != "" {
+		ov, err := cewrap.ParseCEOverrides(o.ceOverrides)
+		if err != nil {
+			return nil, err
+		}
+		so = append(so, cewrap.WithCEOverrides(ov))
+	}
+
+	if m := o.getTransforms(); m != nil {
+		so = append(so, cewrap.WithEventMutators(m))
+	}
+
+	if o.filter != "" {
+		f, err := cewrap.CESQLFilter(o.filter)
+		if err != nil {
+			return nil, err
+		}
+		so = append(so, cewrap.WithEventFilters(f))
+	}
+
+	// create the downstream client
+	dc, err := o.newDownstreamClient()
 	if err != nil {
 		return nil, err
 	}
+	if dc != nil {
+		so = append(so, cewrap.WithHTTPClient(dc))
+	}
+
+	if o.logSampling != "" {
+		n, _ := strconv.ParseUint(o.logSampling, 10, 64)
+		so = append(so, cewrap.WithLogSampling(n))
+	}
 
 	so = append(so,
 		cewrap.WithDownstream(o.downstream),
@@ -235,7 +1511,131 @@ func (o *options) getSourceOptions() ([]cewrap.SourceOption, error) {
 		cewrap.WithDataschema(o.dataschema),
 		cewrap.WithTypePrefix(o.typePrefix),
 		cewrap.WithPathPrefix(o.pathPrefix),
-		cewrap.WithSink(sink),
+		cewrap.WithRequestIDHeader(o.requestIDHeader),
 	)
 	return so, nil
 }
+
+// getSinks creates the extra sinks from the config file.
+func (o *options) getSinks() ([]cewrap.Sink, error) {
+	var sinks []cewrap.Sink
+	for _, sc := range o.sinks {
+		c, err := o.newSinkClient(sc.URL)
+		if err != nil {
+			return nil, fmt.Errorf("error creating sink %s: %w", sc.Name, err)
+		}
+		si := cewrap.Sink{
+			Name:    sc.Name,
+			Sender:  c,
+			Retries: sc.Retries,
+		}
+		si.Timeout, _ = time.ParseDuration(sc.Timeout)
+		si.RetryDelay, _ = time.ParseDuration(sc.RetryDelay)
+		if len(sc.Filter.Types) > 0 {
+			si.Filters = append(si.Filters, cewrap.TypeFilter(sc.Filter.Types...))
+		}
+		if len(sc.Filter.Subjects) > 0 {
+			si.Filters = append(si.Filters, cewrap.SubjectFilter(sc.Filter.Subjects...))
+		}
+		for k, v := range sc.Filter.Extensions {
+			si.Filters = append(si.Filters, cewrap.ExtensionFilter(k, v))
+		}
+		if sc.Filter.SQL != "" {
+			f, err := cewrap.CESQLFilter(sc.Filter.SQL)
+			if err != nil {
+				return nil, fmt.Errorf("error creating sink %s: %w", sc.Name, err)
+			}
+			si.Filters = append(si.Filters, f)
+		}
+		sinks = append(sinks, si)
+	}
+	return sinks, nil
+}
+
+// getTransforms returns the mutator for the transformation of the event data,
+// or nil when no transformation is configured.
+func (o *options) getTransforms() cewrap.EventMutator {
+	var routes []cewrap.TransformRoute
+	for _, rc := range o.routes {
+		if rc.Transform == nil {
+			continue
+		}
+		routes = append(routes, cewrap.TransformRoute{
+			Paths:     rc.Paths,
+			Transform: rc.Transform.dataTransform(),
+		})
+	}
+	if o.transform == nil && len(routes) == 0 {
+		return nil
+	}
+	var def *cewrap.DataTransform
+	if o.transform != nil {
+		def = o.transform.dataTransform()
+	}
+	return cewrap.RouteTransforms(def, routes...)
+}
+
+// getRedactor returns the redactor for the redaction rules,
+// or nil when there are no rules.
+func (o *options) getRedactor() *cewrap.Redactor {
+	if len(o.redactRules) == 0 {
+		return nil
+	}
+	r := &cewrap.Redactor{Salt: o.redactionSalt}
+	for _, rc := range o.redactRules {
+		rule := cewrap.RedactRule{
+			Paths:   rc.Paths,
+			Keys:    rc.Keys,
+			Headers: rc.Headers,
+			Action:  cewrap.RedactAction(rc.Action),
+		}
+		for _, v := range rc.Values {
+			rule.Values = append(rule.Values, regexp.MustCompile(v))
+		}
+		r.Rules = append(r.Rules, rule)
+	}
+	return r
+}
+
+// newSinkClient creates the client that sends the events to target,
+// with the sink TLS and authentication settings.
+func (o *options) newSinkClient(target string) (cloudevents.Client, error) {
+	copts := []cehttp.Option{cloudevents.WithTarget(target)}
+	if o.sinkTLS.isSet() || o.sinkAuth.method() != "none" {
+		var rt http.RoundTripper = http.DefaultTransport
+		if o.sinkTLS.isSet() {
+			tr := http.DefaultTransport.(*http.Transport).Clone()
+			if err := o.sinkTLS.configure(tr); err != nil {
+				return nil, err
+			}
+			rt = tr
+		}
+		copts = append(copts, cloudevents.WithRoundTripper(o.sinkAuth.transport(rt)))
+	}
+	return client.NewHTTP(copts...)
+}
+
+// newDownstreamClient creates the client for the downstream service with the
+// TLS and transport settings, nil when none are set.
+func (o *options) newDownstreamClient() (*http.Client, error) {
+	if !o.downstreamTLS.isSet() && o.downstreamMaxIdleConnsPerHost == "" &&
+		o.downstreamIdleConnTimeout == "" && o.downstreamHTTP2 == "" {
+		return nil, nil
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if err := o.downstreamTLS.configure(tr); err != nil {
+		return nil, err
+	}
+	if o.downstreamMaxIdleConnsPerHost != "" {
+		tr.MaxIdleConnsPerHost, _ = strconv.Atoi(o.downstreamMaxIdleConnsPerHost)
+	}
+	if o.downstreamIdleConnTimeout != "" {
+		tr.IdleConnTimeout, _ = time.ParseDuration(o.downstreamIdleConnTimeout)
+	}
+	if http2, err := strconv.ParseBool(o.downstreamHTTP2); err == nil && !http2 {
+		// A non-nil empty map disables HTTP/2.
+		tr.ForceAttemptHTTP2 = false
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return &http.Client{Transport: tr}, nil
+}
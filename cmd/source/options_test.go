@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/myhops/cewrap"
 	"github.com/stretchr/testify/assert"
@@ -129,6 +130,7 @@ func TestArgsEnv(t *testing.T) {
 				downstream:    "http://example.com/downstream",
 				logFormat:     "text",
 				logLevel:      "info",
+				traceExporter: "none",
 				changeMethods: []string{"POST", "DELETE", "PATCH", "PUT"},
 			},
 		},
@@ -150,6 +152,7 @@ func TestArgsEnv(t *testing.T) {
 				downstream:    "http://example.com/downstream",
 				logFormat:     "text",
 				logLevel:      "info",
+				traceExporter: "none",
 				changeMethods: []string{"POST", "DELETE", "PATCH", "PUT"},
 			},
 		},
@@ -171,6 +174,7 @@ func TestArgsEnv(t *testing.T) {
 				downstream:    "http://example.com/downstream",
 				logFormat:     "text",
 				logLevel:      "info",
+				traceExporter: "none",
 				changeMethods: []string{"POST", "DELETE", "PATCH", "PUT"},
 			},
 		},
@@ -199,6 +203,7 @@ func TestArgsEnv(t *testing.T) {
 				typePrefix:       "typeprefix",
 				logFormat:        "text",
 				logLevel:         "info",
+				traceExporter:    "none",
 				changeMethods:    []string{"PUT", "POST"},
 				changeMethodsSet: true,
 			},
@@ -228,6 +233,7 @@ func TestArgsEnv(t *testing.T) {
 				typePrefix:       "typeprefix",
 				logFormat:        "text",
 				logLevel:         "info",
+				traceExporter:    "none",
 				changeMethods:    []string{"GET", "POST", "DELETE", "PATCH", "PUT"},
 				changeMethodsSet: false,
 			},
@@ -242,3 +248,138 @@ func TestArgsEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestCEOverrides(t *testing.T) {
+	env := []string{
+		"K_SINK=http://example.com/sink",
+		"CEW_DOWNSTREAM=http://example.com/downstream",
+		`K_CE_OVERRIDES={"extensions":{"cluster":"prod"}}`,
+	}
+	opts, err := getOptionsFrom(nil, env)
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"extensions":{"cluster":"prod"}}`, opts.ceOverrides)
+	}
+
+	env[2] = `K_CE_OVERRIDES={"extensions":{"Cluster":"prod"}}`
+	_, err = getOptionsFrom(nil, env)
+	assert.ErrorContains(t, err, `K_CE_OVERRIDES: extension name "Cluster"`)
+}
+
+func TestJWTOptions(t *testing.T) {
+	env := []string{
+		"K_SINK=http://example.com/sink",
+		"CEW_DOWNSTREAM=http://example.com/downstream",
+		"CEW_JWT_MODE=extract",
+		"CEW_JWT_OPTIONAL=true",
+	}
+	opts, err := getOptionsFrom(nil, env)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a := opts.getJWTAuth()
+	if assert.NotNil(t, a) {
+		assert.Nil(t, a.Keys)
+		assert.True(t, a.Optional)
+	}
+
+	opts, err = getOptionsFrom([]string{"-jwt-mode", "validate", "-jwt-jwks-url", "https://login.example.com/jwks", "-jwt-audience", "crm"}, env[:2])
+	if !assert.NoError(t, err) {
+		return
+	}
+	a = opts.getJWTAuth()
+	if assert.NotNil(t, a) {
+		assert.Equal(t, "https://login.example.com/jwks", a.Keys.URL)
+		assert.Equal(t, "crm", a.Audience)
+	}
+
+	_, err = getOptionsFrom([]string{"-jwt-mode", "extract", "-jwt-issuer", "https://login.example.com"}, env[:2])
+	assert.ErrorContains(t, err, "jwt: the key set, issuer and audience are only used for mode validate")
+	_, err = getOptionsFrom([]string{"-jwt-mode", "check"}, env[:2])
+	assert.ErrorContains(t, err, `jwt.mode: unknown mode "check", use validate or extract`)
+}
+
+func TestAccessOptions(t *testing.T) {
+	env := []string{
+		"K_SINK=http://example.com/sink",
+		"CEW_DOWNSTREAM=http://example.com/downstream",
+	}
+	opts, err := getOptionsFrom(nil, env)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, opts.getAccess())
+
+	name := writeConfig(t, "config.yaml", `
+access:
+  rules:
+    - paths: ["/persons*"]
+      methods: ["DELETE"]
+      action: deny
+  status: 403
+`)
+	opts, err = getOptionsFrom([]string{"-config", name, "-deny-paths", "/actuator*, /debug/*", "-allow-paths", "/persons*"}, append(env, "CEW_BLOCKED_EVENT=true"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &cewrap.AccessControl{
+		Rules: []cewrap.AccessRule{
+			{Paths: []string{"/actuator*", "/debug/*"}, Action: cewrap.AccessDeny},
+			{Paths: []string{"/persons*"}, Methods: []string{"DELETE"}, Action: cewrap.AccessDeny},
+			{Paths: []string{"/persons*"}, Action: cewrap.AccessAllow},
+		},
+		Status: 403,
+		Event:  true,
+	}, opts.getAccess())
+}
+
+func TestCORSOptions(t *testing.T) {
+	env := []string{
+		"K_SINK=http://example.com/sink",
+		"CEW_DOWNSTREAM=http://example.com/downstream",
+		"CEW_CORS_ALLOWED_ORIGINS=https://app.example.com, https://*.example.org",
+		"CEW_CORS_ALLOW_CREDENTIALS=true",
+	}
+	opts, err := getOptionsFrom([]string{"-cors-allowed-methods", "GET,PUT", "-cors-allowed-headers", "*", "-cors-max-age", "10m"}, env)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &cewrap.CORS{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowedHeaders:   []string{"*"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}, opts.getCORS())
+
+	opts, err = getOptionsFrom(nil, env[:2])
+	if assert.NoError(t, err) {
+		assert.Nil(t, opts.getCORS())
+	}
+}
+
+func TestBodyLimitOptions(t *testing.T) {
+	env := []string{
+		"K_SINK=http://example.com/sink",
+		"CEW_DOWNSTREAM=http://example.com/downstream",
+	}
+	opts, err := getOptionsFrom(nil, env)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, opts.getBodyLimits())
+
+	name := writeConfig(t, "config.yaml", `
+routes:
+  - paths: ["/uploads*"]
+    maxBodySize: 10485760
+`)
+	opts, err = getOptionsFrom([]string{"-config", name, "-max-body-size", "1048576"}, append(env, "CEW_CONTENT_TYPES=application/json,application/*+json"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, &cewrap.BodyLimits{
+		MaxBytes:     1048576,
+		Routes:       []cewrap.RouteBodyLimit{{Paths: []string{"/uploads*"}, MaxBytes: 10485760}},
+		ContentTypes: []string{"application/json", "application/*+json"},
+	}, opts.getBodyLimits())
+}
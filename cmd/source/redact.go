@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runRedact implements the redact command, it shows the effect of the
+// redaction rules in the config file on a sample payload.
+//
+//	source redact -config config.yaml [-content-type type] [payload file]
+//
+// The payload is read from stdin when no file is given.
+func runRedact(args, env []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("redact", flag.ContinueOnError)
+	configFile := fs.String("config", "", "configuration file with the redaction rules")
+	contentType := fs.String("content-type", "application/json", "content type of the payload")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cf := *configFile
+	if cf == "" {
+		eo := &options{}
+		eo.getEnv(env)
+		cf = eo.configFile
+	}
+	if cf == "" {
+		return errors.New("no config file set")
+	}
+	cfg, err := readConfigFile(cf)
+	if err != nil {
+		return err
+	}
+	opts := &options{}
+	cfg.apply(opts)
+	opts.getEnv(env)
+	if err := errors.Join(opts.validateRedaction()...); err != nil {
+		return err
+	}
+	r := opts.getRedactor()
+	if r == nil {
+		return fmt.Errorf("no redaction rules in %s", cf)
+	}
+
+	in := stdin
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	payload, err := io.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	out, err := r.RedactBody(*contentType, payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(stdout, string(out))
+	return err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactCommand(t *testing.T) {
+	cf := writeConfig(t, "config.yaml", `
+redaction:
+  rules:
+    - keys: ["*password*", "*token*"]
+    - paths: [customer.email]
+      action: hash
+    - values: ['\b[A-Z]{2}\d{2}[A-Z0-9]{10,30}\b']
+      action: remove
+`)
+	var out bytes.Buffer
+	in := strings.NewReader(`{"password":"x","customer":{"email":"jan@example.com"},"iban":"NL91ABNA0417164300","id":1}`)
+	err := runRedact([]string{"-config", cf}, []string{"CEW_REDACTION_SALT=pepper"}, in, &out)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Contains(t, out.String(), `"password":"****"`)
+	assert.Contains(t, out.String(), `"email":"sha256:`)
+	assert.NotContains(t, out.String(), "iban")
+	assert.Contains(t, out.String(), `"id":1`)
+
+	// The hash action needs a salt.
+	err = runRedact([]string{"-config", cf}, nil, strings.NewReader("{}"), &out)
+	assert.ErrorContains(t, err, "redaction.salt: not set")
+}
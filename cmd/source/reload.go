@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/myhops/cewrap"
+)
+
+// reloader serves the requests with the current source and swaps in a new
+// source when the configuration is reloaded.
+//
+// In-flight requests keep the source they started with.
+type reloader struct {
+	args      []string
+	env       []string
+	logger    *slog.Logger
+	level     *slog.LevelVar
+	accessLog *cewrap.AccessLog
+
+	// handler holds the http.HandlerFunc of the current source.
+	handler atomic.Value
+
+	// mu serializes the reloads.
+	mu   sync.Mutex
+	opts *options
+}
+
+// newReloader creates the reloader with the source for opts.
+func newReloader(args, env []string, opts *options, logger *slog.Logger, level *slog.LevelVar, accessLog *cewrap.AccessLog) (*reloader, error) {
+	rl := &reloader{
+		args:      args,
+		env:       env,
+		logger:    logger,
+		level:     level,
+		accessLog: accessLog,
+		opts:      opts,
+	}
+	s, err := rl.newSource(opts)
+	if err != nil {
+		return nil, err
+	}
+	rl.handler.Store(s.Handler())
+	return rl, nil
+}
+
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl.handler.Load().(http.HandlerFunc)(w, r)
+}
+
+// newSource creates a source for opts.
+func (rl *reloader) newSource(opts *options) (*cewrap.Source, error) {
+	so, err := opts.getSourceOptions()
+	if err != nil {
+		return nil, err
+	}
+	so = append(so, cewrap.WithLogger(rl.logger))
+	if rl.accessLog != nil {
+		so = append(so, cewrap.WithAccessLog(rl.accessLog))
+	}
+	return cewrap.NewSourceE(so...)
+}
+
+// reload reads the configuration again and swaps the source.
+// When the new configuration is invalid the current source is kept.
+func (rl *reloader) reload(ctx context.Context) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	opts, err := getOptionsFrom(rl.args, rl.env)
+	if err != nil {
+		rl.logger.Error("config reload rejected, keeping the current config", slog.String("err", err.Error()))
+		return err
+	}
+	s, err := rl.newSource(opts)
+	if err != nil {
+		rl.logger.Error("config reload rejected, keeping the current config", slog.String("err", err.Error()))
+		return err
+	}
+	for _, name := range restartRequired(rl.opts, opts) {
+		rl.logger.Warn("changed setting needs a restart", slog.String("setting", name))
+	}
+	if opts.logLevel != rl.opts.logLevel {
+		rl.level.UnmarshalText([]byte(opts.logLevel))
+	}
+
+	rl.handler.Store(s.Handler())
+	rl.opts = opts
+	rl.logger.Info("config reloaded", slog.String("config", opts.configFile))
+	logOptions(opts, rl.logger)
+
+	if opts.reloadEvent == "true" {
+		if err := emitConfigReloaded(ctx, s, opts); err != nil {
+			rl.logger.Error("error emitting config_reloaded event", slog.String("err", err.Error()))
+		}
+	}
+	return nil
+}
+
+// restartRequired returns the names of the settings that differ between
+// old and new and are only applied at startup.
+func restartRequired(old, new *options) []string {
+	var names []string
+	check := func(name, o, n string) {
+		if o != n {
+			names = append(names, name)
+		}
+	}
+	check("port", old.port, new.port)
+	check("adminPort", old.adminPort, new.adminPort)
+	check("httpsPort", old.httpsPort, new.httpsPort)
+	check("tls", fmt.Sprint(old.tls), fmt.Sprint(new.tls))
+	check("log.format", old.logFormat, new.logFormat)
+	check("accessLog.destination", old.accessLog, new.accessLog)
+	check("accessLog.format", old.accessLogFormat, new.accessLogFormat)
+	check("accessLog.maxSize", old.accessLogMaxSize, new.accessLogMaxSize)
+	check("accessLog.maxBackups", old.accessLogMaxBackups, new.accessLogMaxBackups)
+	check("tracing.exporter", old.traceExporter, new.traceExporter)
+	check("configPollInterval", old.configPollInterval, new.configPollInterval)
+	return names
+}
+
+// emitConfigReloaded sends a config_reloaded event to the sinks of s.
+func emitConfigReloaded(ctx context.Context, s *cewrap.Source, opts *options) error {
+	return s.EmitEvent(ctx, "config_reloaded", opts.configFile, map[string]any{
+		"config": opts.configFile,
+	})
+}
+
+// watch reloads the configuration on SIGHUP and, when interval is set,
+// when the content of the config file changes.
+// It returns when ctx is done.
+func (rl *reloader) watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 && rl.opts.configFile != "" {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	sum := fileSum(rl.opts.configFile)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			rl.logger.Info("received SIGHUP, reloading config")
+			rl.reload(ctx)
+			sum = fileSum(rl.opts.configFile)
+		case <-tick:
+			ns := fileSum(rl.opts.configFile)
+			if ns == nil || bytes.Equal(ns, sum) {
+				continue
+			}
+			sum = ns
+			rl.logger.Info("config file changed, reloading config")
+			rl.reload(ctx)
+		}
+	}
+}
+
+// fileSum returns the hash of the content of the file name,
+// or nil when it cannot be read.
+func fileSum(name string) []byte {
+	if name == "" {
+		return nil
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	s := sha256.Sum256(b)
+	return s[:]
+}
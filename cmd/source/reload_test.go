@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	newDownstream := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}))
+	}
+	ds1 := newDownstream("one")
+	defer ds1.Close()
+	ds2 := newDownstream("two")
+	defer ds2.Close()
+
+	var events []string
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events = append(events, r.Header.Get("ce-type")+" "+r.Header.Get("ce-cluster"))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	cf := writeConfig(t, "config.yaml", "downstream: "+ds1.URL+"\nsink: "+sink.URL+"\nsource: urn:test\n")
+	args := []string{"-config", cf, "-reload-event", "true", "-type", "com.example"}
+	env := []string{`K_CE_OVERRIDES={"extensions":{"cluster":"prod"}}`}
+	opts, err := getOptionsFrom(args, env)
+	if err != nil {
+		t.Fatalf("getOptionsFrom error: %s", err)
+	}
+	rl, err := newReloader(args, env, opts, slog.Default(), new(slog.LevelVar), nil)
+	if err != nil {
+		t.Fatalf("newReloader error: %s", err)
+	}
+
+	get := func() string {
+		rr := httptest.NewRecorder()
+		rl.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/path", nil))
+		return rr.Body.String()
+	}
+	assert.Equal(t, "one", get())
+
+	// An invalid config is rejected and the old config stays.
+	os.WriteFile(cf, []byte("downstream: not-a-url\nsink: "+sink.URL+"\n"), 0o600)
+	err = rl.reload(context.Background())
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "downstream:"), err.Error())
+	}
+	assert.Equal(t, "one", get())
+	assert.Empty(t, events)
+
+	// A valid config is swapped in.
+	os.WriteFile(cf, []byte("downstream: "+ds2.URL+"\nsink: "+sink.URL+"\nsource: urn:test\n"), 0o600)
+	assert.NoError(t, rl.reload(context.Background()))
+	assert.Equal(t, "two", get())
+	// The event gets the overrides like the other events.
+	assert.Equal(t, []string{"com.example.config_reloaded prod"}, events)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// serve serves h with plain HTTP on the port and with TLS on the https port.
+// Without an https port TLS, when configured, is served on the port.
+// It returns when one of the servers stops.
+func serve(o *options, h http.Handler, logger *slog.Logger) error {
+	cfg, err := o.tls.serverConfig()
+	if err != nil {
+		return err
+	}
+	errc := make(chan error, 2)
+	if cfg == nil || o.httpsPort != "" {
+		la := ":" + o.port
+		logger.Info("starting server", slog.String("listen_address", la))
+		go func() {
+			errc <- http.ListenAndServe(la, h)
+		}()
+	}
+	if cfg != nil {
+		la := ":" + o.port
+		if o.httpsPort != "" {
+			la = ":" + o.httpsPort
+		}
+		logger.Info("starting TLS server", slog.String("listen_address", la),
+			slog.String("clientAuth", cfg.ClientAuth.String()))
+		srv := &http.Server{Addr: la, Handler: h, TLSConfig: cfg}
+		go func() {
+			// The certificate comes from the config.
+			errc <- srv.ListenAndServeTLS("", "")
+		}()
+	}
+	return <-errc
+}
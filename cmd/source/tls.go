@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tlsOptions configures a TLS connection.
+//
+// The certificate files are read again when they change on disk,
+// so rotated certificates are used without a restart.
+type tlsOptions struct {
+	// PEM encoded CA certificates, trusted besides the system roots.
+	caCerts string
+	// File with PEM encoded CA certificates, trusted besides the system roots.
+	caFile string
+	// Client or server certificate and key files.
+	certFile string
+	keyFile  string
+	// Minimum TLS version, 1.0, 1.1, 1.2 or 1.3.
+	minVersion string
+	// Server name for SNI and verification, instead of the host of the url.
+	serverName string
+	// Skip the verification of the server certificate, only for development.
+	insecureSkipVerify string
+	// Verification of the client certificates by a server, none, request or require.
+	clientAuth string
+}
+
+// isSet returns true when any of the options is set.
+func (t *tlsOptions) isSet() bool {
+	return *t != tlsOptions{}
+}
+
+// clientAuthTypes are the client certificate verifications of a server.
+// Request verifies a certificate when the client sends one.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+// serverClientAuth returns the client certificate verification of the
+// listener, require with a CA and none without when not set.
+func (t *tlsOptions) serverClientAuth() string {
+	if t.clientAuth != "" {
+		return t.clientAuth
+	}
+	if t.caCerts != "" || t.caFile != "" {
+		return "require"
+	}
+	return "none"
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// validate checks the options, the errors are qualified with path.
+func (t *tlsOptions) validate(path string) []error {
+	var errs []error
+	if t.caCerts != "" {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(t.caCerts)) {
+			errs = append(errs, fieldError(path+".caCerts", errors.New("no PEM encoded certificates found")))
+		}
+	}
+	if t.caFile != "" {
+		if _, err := loadCertPool(t.caFile); err != nil {
+			errs = append(errs, fieldError(path+".caFile", err))
+		}
+	}
+	switch {
+	case t.certFile == "" && t.keyFile == "":
+	case t.certFile == "":
+		errs = append(errs, fieldError(path+".certFile", errors.New("not set, needed with keyFile")))
+	case t.keyFile == "":
+		errs = append(errs, fieldError(path+".keyFile", errors.New("not set, needed with certFile")))
+	default:
+		if _, err := tls.LoadX509KeyPair(t.certFile, t.keyFile); err != nil {
+			errs = append(errs, fieldError(path+".certFile", err))
+		}
+	}
+	if t.minVersion != "" {
+		if _, ok := tlsVersions[t.minVersion]; !ok {
+			errs = append(errs, fieldError(path+".minVersion", fmt.Errorf("unknown TLS version %q", t.minVersion)))
+		}
+	}
+	if t.insecureSkipVerify != "" {
+		if _, err := strconv.ParseBool(t.insecureSkipVerify); err != nil {
+			errs = append(errs, fieldError(path+".insecureSkipVerify", fmt.Errorf("%q is not a boolean", t.insecureSkipVerify)))
+		}
+	}
+	if t.clientAuth != "" {
+		if _, ok := clientAuthTypes[t.clientAuth]; !ok {
+			errs = append(errs, fieldError(path+".clientAuth", fmt.Errorf("unknown client auth %q, use none, request or require", t.clientAuth)))
+		}
+	}
+	return errs
+}
+
+// configure sets the TLS config of the client transport tr,
+// it does nothing when no options are set.
+//
+// The server certificate gets the standard verification, against the server
+// name or else the dialed host. When a CA is set, the direct connections are
+// verified against the current roots, so a changed CA file is picked up.
+func (t *tlsOptions) configure(tr *http.Transport) error {
+	cfg, roots, err := t.clientConfig()
+	if err != nil || cfg == nil {
+		return err
+	}
+	tr.TLSClientConfig = cfg
+	if roots == nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		// The transport has added the protocols for HTTP/2 by now.
+		c := tr.TLSClientConfig.Clone()
+		c.RootCAs = roots.pool()
+		if c.ServerName == "" {
+			c.ServerName = host
+		}
+		d := &tls.Dialer{NetDialer: dialer, Config: c}
+		return d.DialContext(ctx, network, addr)
+	}
+	return nil
+}
+
+// clientConfig returns the TLS config for a client connection and the
+// reloader of the trusted roots when a CA is set, nil when no options are set.
+func (t *tlsOptions) clientConfig() (*tls.Config, *rootsReloader, error) {
+	if !t.isSet() {
+		return nil, nil, nil
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if t.minVersion != "" {
+		cfg.MinVersion = tlsVersions[t.minVersion]
+	}
+	cfg.ServerName = t.serverName
+	if t.certFile != "" {
+		kp, err := newKeyPairReloader(t.certFile, t.keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return kp.get()
+		}
+	}
+	if insecure, _ := strconv.ParseBool(t.insecureSkipVerify); insecure {
+		cfg.InsecureSkipVerify = true
+		return cfg, nil, nil
+	}
+	if t.caCerts == "" && t.caFile == "" {
+		return cfg, nil, nil
+	}
+	roots, err := newRootsReloader(t.caCerts, t.caFile, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.RootCAs = roots.pool()
+	return cfg, roots, nil
+}
+
+// serverConfig returns the TLS config for a server, nil when no certificate is set.
+//
+// The client certificates are verified against the CA certificates only,
+// by default a certificate is required when a CA is set.
+func (t *tlsOptions) serverConfig() (*tls.Config, error) {
+	if t.certFile == "" {
+		return nil, nil
+	}
+	kp, err := newKeyPairReloader(t.certFile, t.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return kp.get()
+		},
+	}
+	if t.minVersion != "" {
+		cfg.MinVersion = tlsVersions[t.minVersion]
+	}
+	cfg.ClientAuth = clientAuthTypes[t.serverClientAuth()]
+	if cfg.ClientAuth == tls.NoClientCert {
+		return cfg, nil
+	}
+	roots, err := newRootsReloader(t.caCerts, t.caFile, false)
+	if err != nil {
+		return nil, err
+	}
+	// Use the current client CAs for each connection.
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := cfg.Clone()
+		c.ClientCAs = roots.pool()
+		return c, nil
+	}
+	return cfg, nil
+}
+
+// loadCertPool reads the PEM encoded certificates in name.
+func loadCertPool(name string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no PEM encoded certificates found in %s", name)
+	}
+	return pool, nil
+}
+
+// modTime returns the modification time of name, the zero time when it cannot be read.
+func modTime(name string) time.Time {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// keyPairReloader holds a certificate and key pair and loads it again
+// when one of the files changes.
+type keyPairReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	certMod time.Time
+	keyMod  time.Time
+	keyPair *tls.Certificate
+}
+
+func newKeyPairReloader(certFile, keyFile string) (*keyPairReloader, error) {
+	kp := &keyPairReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := kp.get(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+// get returns the current pair. When the changed files cannot be loaded,
+// e.g. halfway a rotation, it keeps the previous pair.
+func (kp *keyPairReloader) get() (*tls.Certificate, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	cm, km := modTime(kp.certFile), modTime(kp.keyFile)
+	if kp.keyPair != nil && cm.Equal(kp.certMod) && km.Equal(kp.keyMod) {
+		return kp.keyPair, nil
+	}
+	pair, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		if kp.keyPair != nil {
+			return kp.keyPair, nil
+		}
+		return nil, fmt.Errorf("error loading key pair: %w", err)
+	}
+	kp.keyPair, kp.certMod, kp.keyMod = &pair, cm, km
+	return kp.keyPair, nil
+}
+
+// rootsReloader holds the trusted roots: optionally the system roots, the PEM encoded
+// certificates and the certificates in a file that is loaded again when it changes.
+type rootsReloader struct {
+	caCerts string
+	caFile  string
+	system  bool
+
+	mu    sync.Mutex
+	mod   time.Time
+	roots *x509.CertPool
+}
+
+func newRootsReloader(caCerts, caFile string, system bool) (*rootsReloader, error) {
+	r := &rootsReloader{caCerts: caCerts, caFile: caFile, system: system}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rootsReloader) load() error {
+	roots := x509.NewCertPool()
+	if r.system {
+		if sp, err := x509.SystemCertPool(); err == nil {
+			roots = sp
+		}
+	}
+	if r.caCerts != "" && !roots.AppendCertsFromPEM([]byte(r.caCerts)) {
+		return errors.New("no PEM encoded CA certificates found")
+	}
+	if r.caFile != "" {
+		mod := modTime(r.caFile)
+		b, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		if !roots.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no PEM encoded certificates found in %s", r.caFile)
+		}
+		r.mod = mod
+	}
+	r.roots = roots
+	return nil
+}
+
+// pool returns the current roots. When the changed file cannot be loaded
+// it keeps the previous roots.
+func (r *rootsReloader) pool() *x509.CertPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.caFile != "" && !modTime(r.caFile).Equal(r.mod) {
+		// load only replaces the roots when it succeeds.
+		r.load()
+	}
+	return r.roots
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA issues certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue writes a certificate for cn, localhost and 127.0.0.1 and its key to dir
+// and returns the file names.
+func (ca *testCA) issue(t *testing.T, dir, cn string) (certFile, keyFile string) {
+	t.Helper()
+	return ca.issueFor(t, dir, cn, []string{cn, "localhost"}, []net.IP{net.ParseIP("127.0.0.1")})
+}
+
+// issueFor writes a certificate for the names and ips and its key to dir
+// and returns the file names.
+func (ca *testCA) issueFor(t *testing.T, dir, cn string, names []string, ips []net.IP) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"cewrap"}},
+		DNSNames:     names,
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, _ := x509.MarshalECPrivateKey(key)
+	certFile = filepath.Join(dir, cn+".crt")
+	keyFile = filepath.Join(dir, cn+".key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}))
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, name string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(name, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newMTLSServer starts a TLS server that requires a client certificate
+// issued by ca and records the common names of the clients.
+func newMTLSServer(t *testing.T, ca *testCA) (*httptest.Server, func() []string) {
+	t.Helper()
+	dir := t.TempDir()
+	cf, kf := ca.issue(t, dir, "server")
+	pair, err := tls.LoadX509KeyPair(cf, kf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	var (
+		mu    sync.Mutex
+		names []string
+	)
+	svr := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		names = append(names, r.TLS.PeerCertificates[0].Subject.CommonName)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	svr.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		// A resumed session does not send the client certificate again.
+		SessionTicketsDisabled: true,
+	}
+	svr.StartTLS()
+	t.Cleanup(svr.Close)
+	return svr, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), names...)
+	}
+}
+
+func TestSinkTLS(t *testing.T) {
+	ca := newTestCA(t)
+	svr, clients := newMTLSServer(t, ca)
+
+	dir := t.TempDir()
+	certFile, keyFile := ca.issue(t, dir, "client1")
+	o := &options{
+		source: "urn:test",
+		sinkTLS: tlsOptions{
+			caCerts:    string(ca.pem),
+			certFile:   certFile,
+			keyFile:    keyFile,
+			minVersion: "1.2",
+		},
+	}
+	assert.Empty(t, o.sinkTLS.validate("sinkTLS"))
+
+	c, err := o.newSinkClient(svr.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	send := func() error {
+		evt := cloudevents.NewEvent()
+		evt.SetID("1")
+		evt.SetSource("urn:test")
+		evt.SetType("test")
+		if result := c.Send(context.Background(), evt); !cloudevents.IsACK(result) {
+			return result
+		}
+		return nil
+	}
+	assert.NoError(t, send())
+
+	// Rotate the client certificate, new connections use the new one.
+	newCert, newKey := ca.issue(t, t.TempDir(), "client2")
+	later := time.Now().Add(time.Minute)
+	for from, to := range map[string]string{newCert: certFile, newKey: keyFile} {
+		b, _ := os.ReadFile(from)
+		writeFile(t, to, b)
+		os.Chtimes(to, later, later)
+	}
+	svr.CloseClientConnections()
+	// Let the client notice the closed connection.
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, send())
+	assert.Equal(t, []string{"client1", "client2"}, clients())
+
+	// Without the CA the server is not trusted.
+	o.sinkTLS.caCerts = ""
+	c, err = o.newSinkClient(svr.URL)
+	if assert.NoError(t, err) {
+		assert.Error(t, send())
+	}
+}
+
+func TestClientTLSVerifiesHost(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	// A certificate of the trusted CA for another name, served at an ip address.
+	cf, kf := ca.issueFor(t, dir, "other", []string{"other.example"}, nil)
+	pair, err := tls.LoadX509KeyPair(cf, kf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	svr.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+	svr.StartTLS()
+	defer svr.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+	get := func(to tlsOptions) error {
+		o := &options{downstreamTLS: to}
+		c, err := o.newDownstreamClient()
+		if err != nil {
+			return err
+		}
+		resp, err := c.Get(svr.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	for _, to := range []tlsOptions{{caCerts: string(ca.pem)}, {caFile: caFile}} {
+		err := get(to)
+		assert.ErrorContains(t, err, "doesn't contain any IP SANs")
+
+		// The server name is verified instead of the host.
+		to.serverName = "other.example"
+		assert.NoError(t, get(to))
+		to.serverName = "wrong.example"
+		assert.Error(t, get(to))
+	}
+}
+
+func TestSinkTLSErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "ca.pem"), []byte("not a certificate"))
+	o := &tlsOptions{
+		caCerts:    "not a certificate",
+		caFile:     filepath.Join(dir, "ca.pem"),
+		certFile:   filepath.Join(dir, "client.crt"),
+		minVersion: "1.4",
+	}
+	var msgs []string
+	for _, err := range o.validate("sinkTLS") {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Contains(t, msgs, "sinkTLS.caCerts: no PEM encoded certificates found")
+	assert.Contains(t, msgs, "sinkTLS.caFile: no PEM encoded certificates found in "+o.caFile)
+	assert.Contains(t, msgs, "sinkTLS.keyFile: not set, needed with certFile")
+	assert.Contains(t, msgs, `sinkTLS.minVersion: unknown TLS version "1.4"`)
+}
+
+func TestDownstreamTLS(t *testing.T) {
+	ca := newTestCA(t)
+	svr, _ := newMTLSServer(t, ca)
+	svr.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto + " " + r.TLS.ServerName + " " + r.TLS.PeerCertificates[0].Subject.CommonName))
+	})
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+	certFile, keyFile := ca.issue(t, dir, "cewrap")
+
+	get := func(o *options) (string, error) {
+		c, err := o.newDownstreamClient()
+		if err != nil {
+			return "", err
+		}
+		resp, err := c.Get(svr.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		return buf.String(), nil
+	}
+
+	o := &options{
+		downstreamTLS: tlsOptions{
+			caFile:     caFile,
+			certFile:   certFile,
+			keyFile:    keyFile,
+			serverName: "server",
+		},
+		downstreamMaxIdleConnsPerHost: "10",
+		downstreamIdleConnTimeout:     "30s",
+		downstreamHTTP2:               "false",
+	}
+	assert.Empty(t, o.downstreamTLS.validate("downstreamTLS"))
+	got, err := get(o)
+	assert.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 server cewrap", got)
+
+	// Without the CA the server is only accepted when the verification is skipped.
+	o.downstreamTLS.caFile = ""
+	_, err = get(o)
+	assert.Error(t, err)
+	o.downstreamTLS.insecureSkipVerify = "true"
+	_, err = get(o)
+	assert.NoError(t, err)
+
+	// No settings, no client.
+	c, err := (&options{}).newDownstreamClient()
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestListenerTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+	certFile, keyFile := ca.issue(t, dir, "server1")
+	clientCert, clientKey := ca.issue(t, dir, "client")
+
+	o := &options{
+		port:      "8080",
+		httpsPort: "8443",
+		tls: tlsOptions{
+			certFile:   certFile,
+			keyFile:    keyFile,
+			caFile:     caFile,
+			clientAuth: "request",
+		},
+	}
+	assert.Empty(t, o.validateListenerTLS())
+
+	start := func() string {
+		cfg, err := o.tls.serverConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id := "anonymous"
+				if len(r.TLS.VerifiedChains) > 0 {
+					id = r.TLS.VerifiedChains[0][0].Subject.CommonName
+				}
+				w.Write([]byte(id))
+			}),
+			TLSConfig: cfg,
+		}
+		go srv.ServeTLS(ln, "", "")
+		t.Cleanup(func() { srv.Close() })
+		return "https://" + ln.Addr().String()
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	get := func(url string, withCert bool) (server, client string, err error) {
+		cfg := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+		if withCert {
+			pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Certificates = []tls.Certificate{pair}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+		resp, err := c.Get(url)
+		if err != nil {
+			return "", "", err
+		}
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		return resp.TLS.PeerCertificates[0].Subject.CommonName, buf.String(), nil
+	}
+
+	// The client certificate is optional.
+	url := start()
+	server, client, err := get(url, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "server1", server)
+	assert.Equal(t, "client", client)
+	_, client, err = get(url, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "anonymous", client)
+
+	// A rotated server certificate is used for new connections.
+	newCert, newKey := ca.issue(t, t.TempDir(), "server2")
+	later := time.Now().Add(time.Minute)
+	for from, to := range map[string]string{newCert: certFile, newKey: keyFile} {
+		b, _ := os.ReadFile(from)
+		writeFile(t, to, b)
+		os.Chtimes(to, later, later)
+	}
+	server, _, err = get(url, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "server2", server)
+
+	// The client certificate is required.
+	o.tls.clientAuth = ""
+	url = start()
+	_, _, err = get(url, false)
+	assert.Error(t, err)
+	_, client, err = get(url, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "client", client)
+
+	// Without TLS settings there is no TLS.
+	cfg, err := (&tlsOptions{}).serverConfig()
+	assert.NoError(t, err)
+	assert.Nil(t, cfg)
+}
+
+func TestListenerTLSErrors(t *testing.T) {
+	o := &options{
+		port:      "8443",
+		httpsPort: "8443",
+		tls: tlsOptions{
+			clientAuth: "require",
+			serverName: "localhost",
+		},
+		sinkTLS: tlsOptions{clientAuth: "request"},
+	}
+	var msgs []string
+	for _, err := range o.validateListenerTLS() {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"tls.certFile: not set, needed for TLS",
+		"tls: serverName and insecureSkipVerify are only used for client connections",
+		"tls.clientAuth: needs caFile or caCerts",
+		"sinkTLS.clientAuth: only used for the listener",
+		"httpsPort: must differ from port and adminPort",
+		"httpsPort: needs tls.certFile",
+		"httpsPort: cannot be used with tls.clientAuth require, the default with a CA",
+	}, msgs)
+
+	// A CA requires the client certificates by default.
+	o = &options{httpsPort: "8443", tls: tlsOptions{certFile: "a.crt", keyFile: "a.key", caFile: "ca.crt"}}
+	msgs = nil
+	for _, err := range o.validateListenerTLS() {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Contains(t, msgs, "httpsPort: cannot be used with tls.clientAuth require, the default with a CA")
+
+	o = &options{tls: tlsOptions{certFile: "a.crt", keyFile: "a.key", clientAuth: "maybe"}}
+	msgs = nil
+	for _, err := range o.validateListenerTLS() {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Contains(t, msgs, `tls.clientAuth: unknown client auth "maybe", use none, request or require`)
+}
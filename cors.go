@@ -0,0 +1,194 @@
+package cewrap
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSMethods are the methods allowed when CORS.AllowedMethods is empty.
+var DefaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// CORS answers the preflight requests of browsers and sets the CORS headers
+// on the responses, so the downstream service does not have to implement CORS.
+//
+// The preflights are answered without calling the downstream service.
+// The CORS headers of the downstream responses are replaced.
+type CORS struct {
+	// AllowedOrigins are the origins that may call, e.g. https://*.example.com.
+	// A * matches any text, a single * allows all origins.
+	AllowedOrigins []string
+	// AllowedMethods are the methods that may be used, DefaultCORSMethods when empty.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers that may be sent, a * allows all.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers the browser makes available.
+	ExposedHeaders []string
+	// AllowCredentials allows requests with cookies and authorization.
+	// The origins must then be explicit, see ExplicitOrigin.
+	AllowCredentials bool
+	// MaxAge is how long the browser caches a preflight, not sent when 0.
+	MaxAge time.Duration
+}
+
+// validate checks the settings.
+func (c *CORS) validate() error {
+	var errs []error
+	if len(c.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("cors: no allowed origins"))
+	}
+	for _, m := range c.AllowedMethods {
+		if m == "" || strings.ContainsAny(m, " \t,") {
+			errs = append(errs, errors.New("cors: invalid method "+strconv.Quote(m)))
+		}
+	}
+	if c.MaxAge < 0 {
+		errs = append(errs, errors.New("cors: max age is negative"))
+	}
+	if c.AllowCredentials {
+		for _, o := range c.AllowedOrigins {
+			if !ExplicitOrigin(o) {
+				errs = append(errs, errors.New("cors: origin "+strconv.Quote(o)+" matches any site, not allowed with credentials"))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ExplicitOrigin reports whether the origin pattern o only matches the origins
+// of a known site: o has no *, or a * is only followed by a domain like
+// in https://*.example.com. Only these patterns can be used with credentials.
+func ExplicitOrigin(o string) bool {
+	i := strings.LastIndex(o, "*")
+	if i < 0 {
+		return true
+	}
+	_, host, ok := strings.Cut(o, "://")
+	if !ok || strings.Contains(o[:i], "*") {
+		return false
+	}
+	suffix := o[i+1:]
+	return strings.HasPrefix(suffix, ".") && strings.Contains(suffix[1:], ".") && strings.HasPrefix(host, "*")
+}
+
+// allowOrigin reports whether origin may call.
+func (c *CORS) allowOrigin(origin string) bool {
+	return matchAnyFold(c.AllowedOrigins, origin)
+}
+
+// allowAnyOrigin reports whether all origins may call.
+func (c *CORS) allowAnyOrigin() bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// methods returns the allowed methods.
+func (c *CORS) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return DefaultCORSMethods
+	}
+	return c.AllowedMethods
+}
+
+// allowMethod reports whether method may be used.
+func (c *CORS) allowMethod(method string) bool {
+	for _, m := range c.methods() {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders reports whether the comma separated headers may be sent.
+func (c *CORS) allowHeaders(headers string) bool {
+	for _, h := range strings.Split(headers, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		allowed := false
+		for _, a := range c.AllowedHeaders {
+			if a == "*" || strings.EqualFold(a, h) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// isPreflight reports whether r is a preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// setOrigin sets the headers for an allowed origin.
+func (c *CORS) setOrigin(h http.Header, origin string) {
+	// The origin is echoed with credentials, they cannot be used with a * origin.
+	if c.allowAnyOrigin() && !c.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers the preflight request r. The headers are only set
+// when the origin, the method and the headers are allowed, so the browser
+// blocks the request otherwise.
+func (c *CORS) preflight(w http.ResponseWriter, r *http.Request) bool {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	origin := r.Header.Get("Origin")
+	reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+	ok := c.allowOrigin(origin) &&
+		c.allowMethod(r.Header.Get("Access-Control-Request-Method")) &&
+		c.allowHeaders(reqHeaders)
+	if ok {
+		c.setOrigin(h, origin)
+		h.Set("Access-Control-Allow-Methods", strings.Join(c.methods(), ", "))
+		if reqHeaders != "" {
+			// The requested headers have been checked, echo them for a *.
+			h.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		if c.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return ok
+}
+
+// responseHeaders replaces the CORS headers of the response in h with the
+// ones of the source for the request origin.
+func (c *CORS) responseHeaders(h http.Header, origin string) {
+	for k := range h {
+		if strings.HasPrefix(k, "Access-Control-") {
+			h.Del(k)
+		}
+	}
+	if origin != "" && c.allowOrigin(origin) {
+		c.setOrigin(h, origin)
+		if len(c.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+	} else {
+		h.Add("Vary", "Origin")
+	}
+}
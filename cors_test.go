@@ -0,0 +1,180 @@
+package cewrap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	calls := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// A downstream with its own, inconsistent CORS headers.
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("X-Total-Count", "3")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer svr.Close()
+
+	s, err := NewSourceE(
+		WithDownstream(svr.URL),
+		WithCORS(&CORS{
+			AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+			AllowedMethods:   []string{http.MethodGet, http.MethodPut},
+			AllowedHeaders:   []string{"Authorization", "Content-Type"},
+			ExposedHeaders:   []string{"X-Total-Count"},
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := s.Handler()
+	do := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/persons", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		rr := do(http.MethodOptions, "https://app.example.com", map[string]string{
+			"Access-Control-Request-Method":  "PUT",
+			"Access-Control-Request-Headers": "content-type, authorization",
+		})
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "GET, PUT", rr.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "content-type, authorization", rr.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("preflight not allowed", func(t *testing.T) {
+		for _, tc := range []struct {
+			origin, method, headers string
+		}{
+			{"https://evil.example.com", "PUT", ""},
+			{"https://app.example.com", "DELETE", ""},
+			{"https://app.example.com", "PUT", "X-Debug"},
+		} {
+			rr := do(http.MethodOptions, tc.origin, map[string]string{
+				"Access-Control-Request-Method":  tc.method,
+				"Access-Control-Request-Headers": tc.headers,
+			})
+			assert.Equal(t, http.StatusNoContent, rr.Code)
+			assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+		}
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("request", func(t *testing.T) {
+		rr := do(http.MethodGet, "https://api.example.org", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "https://api.example.org", rr.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "X-Total-Count", rr.Header().Get("Access-Control-Expose-Headers"))
+		assert.Contains(t, rr.Header().Values("Vary"), "Origin")
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("request from other origin", func(t *testing.T) {
+		rr := do(http.MethodGet, "https://evil.example.com", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		// The header of the downstream is removed.
+		assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	})
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithCORS(&CORS{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/persons", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "X-Tenant")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Tenant", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestCORSMiddlewareWritesNothing(t *testing.T) {
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithCORS(&CORS{AllowedOrigins: []string{"https://app.example.com"}}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// A handler that writes nothing gets 200 OK from net/http.
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}))
+	svr := httptest.NewServer(h)
+	defer svr.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, svr.URL+"/persons", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestOptionsIsNoChangeMethod(t *testing.T) {
+	_, err := NewSourceE(WithMiddlewareMode(), WithChangeMethods([]string{http.MethodPost, http.MethodOptions}))
+	assert.ErrorContains(t, err, "OPTIONS cannot be a change method")
+
+	s, err := NewSourceE(WithMiddlewareMode())
+	if assert.NoError(t, err) {
+		assert.False(t, s.isChange(http.MethodOptions))
+	}
+}
+
+func TestCORSErrors(t *testing.T) {
+	_, err := NewSourceE(WithMiddlewareMode(), WithCORS(&CORS{AllowedMethods: []string{"GET,PUT"}, MaxAge: -time.Second}))
+	assert.ErrorContains(t, err, "cors: no allowed origins")
+	assert.ErrorContains(t, err, `cors: invalid method "GET,PUT"`)
+	assert.ErrorContains(t, err, "cors: max age is negative")
+
+	_, err = NewSourceE(WithMiddlewareMode(), WithCORS(&CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}))
+	assert.ErrorContains(t, err, `cors: origin "*" matches any site, not allowed with credentials`)
+}
+
+func TestExplicitOrigin(t *testing.T) {
+	for o, want := range map[string]bool{
+		"https://app.example.com":   true,
+		"https://*.example.com":     true,
+		"*":                         false,
+		"https://*":                 false,
+		"https://*.com":             false,
+		"https://*.example.*":       false,
+		"https://app.example.com*":  false,
+		"https://*evil.example.com": false,
+	} {
+		assert.Equal(t, want, ExplicitOrigin(o), o)
+	}
+}
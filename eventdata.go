@@ -0,0 +1,159 @@
+package cewrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// DataMode selects what the data of the event holds.
+type DataMode string
+
+const (
+	// DataResponse sets the data to the response body, the default.
+	DataResponse DataMode = "response"
+	// DataRequest sets the data to the request body.
+	DataRequest DataMode = "request"
+	// DataBoth sets the data to an envelope with the request and the response.
+	DataBoth DataMode = "both"
+	// DataAuto sets the data to the response body, or to the request body
+	// when the response body is empty, e.g. on a 204 or a DELETE.
+	DataAuto DataMode = "auto"
+)
+
+// DataOmittedExtension is set to true on the events that miss a body
+// because it is larger than the limit.
+const DataOmittedExtension = "dataomitted"
+
+// EventData configures the data of the events built by the DefaultEventBuilder.
+type EventData struct {
+	// Mode is DataResponse when not set.
+	Mode DataMode
+	// Headers are the request and response headers in the DataBoth envelope.
+	Headers []string
+	// MaxRequestBytes is the max size of the request body in the event, no limit when 0.
+	MaxRequestBytes int
+	// MaxResponseBytes is the max size of the response body in the event, no limit when 0.
+	MaxResponseBytes int
+}
+
+// dataEnvelope is the data of the events in DataBoth mode.
+type dataEnvelope struct {
+	Request  envelopeRequest  `json:"request"`
+	Response envelopeResponse `json:"response"`
+}
+
+type envelopeRequest struct {
+	Method  string              `json:"method"`
+	Path    string              `json:"path"`
+	Query   map[string][]string `json:"query,omitempty"`
+	Headers map[string]string   `json:"headers,omitempty"`
+	Body    json.RawMessage     `json:"body,omitempty"`
+}
+
+type envelopeResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// setData sets the data of evt from info.
+func (d *EventData) setData(evt *cloudevents.Event, info *EventInfo) error {
+	reqType := requestContentType(info)
+	switch d.Mode {
+	case DataRequest:
+		return d.setPart(evt, reqType, info.RequestBody, d.MaxRequestBytes)
+	case DataAuto:
+		if len(info.ResponseBody) == 0 {
+			return d.setPart(evt, reqType, info.RequestBody, d.MaxRequestBytes)
+		}
+		return d.setPart(evt, info.ContentType, info.ResponseBody, d.MaxResponseBytes)
+	case DataBoth:
+		env := dataEnvelope{
+			Request: envelopeRequest{
+				Method: info.Method,
+				Path:   info.Path,
+			},
+			Response: envelopeResponse{
+				Status:  info.Status,
+				Headers: selectHeaders(info.ResponseHeader, d.Headers),
+			},
+		}
+		if info.Request != nil {
+			env.Request.Query = info.Request.URL.Query()
+			env.Request.Headers = selectHeaders(info.Request.Header, d.Headers)
+		}
+		var err error
+		if env.Request.Body, err = d.envelopeBody(evt, reqType, info.RequestBody, d.MaxRequestBytes); err != nil {
+			return err
+		}
+		if env.Response.Body, err = d.envelopeBody(evt, info.ContentType, info.ResponseBody, d.MaxResponseBytes); err != nil {
+			return err
+		}
+		return evt.SetData(cloudevents.ApplicationJSON, env)
+	default:
+		return d.setPart(evt, info.ContentType, info.ResponseBody, d.MaxResponseBytes)
+	}
+}
+
+// setPart sets body as the data of evt, unless it is larger than max.
+func (d *EventData) setPart(evt *cloudevents.Event, contentType string, body []byte, max int) error {
+	if max > 0 && len(body) > max {
+		evt.SetExtension(DataOmittedExtension, true)
+		return nil
+	}
+	const jsonType = "application/json"
+	if strings.Index(contentType, jsonType) == 0 {
+		// Copied from Event.SetData for data is not a byte array.
+		evt.SetDataContentType(jsonType)
+		evt.DataEncoded = body
+		evt.DataBase64 = false
+		return nil
+	}
+	return evt.SetData(contentType, body)
+}
+
+// envelopeBody returns body as JSON for the envelope: JSON bodies as is,
+// text as a string and other content as a base64 string.
+func (d *EventData) envelopeBody(evt *cloudevents.Event, contentType string, body []byte, max int) (json.RawMessage, error) {
+	if len(body) == 0 {
+		return nil, nil
+	}
+	if max > 0 && len(body) > max {
+		evt.SetExtension(DataOmittedExtension, true)
+		return nil, nil
+	}
+	if isJSON(contentType) && json.Valid(body) {
+		return body, nil
+	}
+	if contentType == "" || strings.HasPrefix(strings.ToLower(contentType), "text/") {
+		return json.Marshal(string(body))
+	}
+	return json.Marshal(body)
+}
+
+// requestContentType returns the content type of the request in info.
+func requestContentType(info *EventInfo) string {
+	if info.Request == nil {
+		return ""
+	}
+	return info.Request.Header.Get("Content-Type")
+}
+
+// selectHeaders returns the first value of the headers in h with the names.
+func selectHeaders(h http.Header, names []string) map[string]string {
+	var res map[string]string
+	for _, name := range names {
+		v := h.Get(name)
+		if v == "" {
+			continue
+		}
+		if res == nil {
+			res = map[string]string{}
+		}
+		res[http.CanonicalHeaderKey(name)] = v
+	}
+	return res
+}
@@ -0,0 +1,116 @@
+package cewrap
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventDataModes(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/persons?tenant=acme", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tenant", "acme")
+	info := func(respBody string) *EventInfo {
+		h := http.Header{}
+		h.Set("Content-Type", "application/json")
+		h.Set("Location", "/persons/1")
+		return &EventInfo{
+			Request:        req,
+			RequestBody:    []byte(`{"name":"Jan"}`),
+			Method:         http.MethodPost,
+			Path:           "/persons",
+			Status:         http.StatusCreated,
+			ResponseHeader: h,
+			ContentType:    "application/json",
+			ResponseBody:   []byte(respBody),
+		}
+	}
+
+	cases := []struct {
+		name    string
+		data    EventData
+		resp    string
+		want    string
+		omitted bool
+	}{
+		{name: "response", resp: `{"id":1}`, want: `{"id":1}`},
+		{name: "request", data: EventData{Mode: DataRequest}, resp: `{"id":1}`, want: `{"name":"Jan"}`},
+		{name: "auto with response", data: EventData{Mode: DataAuto}, resp: `{"id":1}`, want: `{"id":1}`},
+		{name: "auto without response", data: EventData{Mode: DataAuto}, want: `{"name":"Jan"}`},
+		{
+			name: "both",
+			data: EventData{Mode: DataBoth, Headers: []string{"x-tenant", "Location"}},
+			resp: `{"id":1}`,
+			want: `{
+				"request": {"method":"POST","path":"/persons","query":{"tenant":["acme"]},"headers":{"X-Tenant":"acme"},"body":{"name":"Jan"}},
+				"response": {"status":201,"headers":{"Location":"/persons/1"},"body":{"id":1}}
+			}`,
+		},
+		{
+			name:    "both with limit",
+			data:    EventData{Mode: DataBoth, MaxResponseBytes: 4},
+			resp:    `{"id":1}`,
+			want:    `{"request":{"method":"POST","path":"/persons","query":{"tenant":["acme"]},"body":{"name":"Jan"}},"response":{"status":201}}`,
+			omitted: true,
+		},
+		{name: "response over limit", data: EventData{MaxResponseBytes: 4}, resp: `{"id":1}`, omitted: true},
+	}
+
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			b := &DefaultEventBuilder{Source: "urn:test", TypePrefix: "test", Data: cc.data}
+			evt, err := b.BuildEvent(context.Background(), info(cc.resp))
+			if !assert.NoError(t, err) {
+				return
+			}
+			if cc.want == "" {
+				assert.Empty(t, evt.Data())
+			} else {
+				assert.JSONEq(t, cc.want, string(evt.Data()))
+			}
+			_, omitted := evt.Extensions()[DataOmittedExtension]
+			assert.Equal(t, cc.omitted, omitted)
+		})
+	}
+}
+
+func TestMiddlewareRequestData(t *testing.T) {
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithSink(sink),
+		WithSource("urn:test"),
+		WithEventData(EventData{Mode: DataAuto}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		buf.ReadFrom(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodPut, "/persons/1", bytes.NewBufferString("Hallo daar"))
+	req.Header.Set("Content-Type", "text/plain")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var evt cloudevents.Event
+	select {
+	case evt = <-echan:
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	assert.Equal(t, "Hallo daar", string(evt.Data()))
+
+	_, err = NewSourceE(WithMiddlewareMode(), WithEventData(EventData{Mode: "all"}))
+	assert.ErrorContains(t, err, `unknown data mode "all"`)
+}
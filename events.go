@@ -0,0 +1,117 @@
+package cewrap
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/google/uuid"
+)
+
+// EventInfo holds the request and response metadata of a handled request,
+// for building the event.
+type EventInfo struct {
+	// Request is the incoming request, its body has been consumed.
+	Request *http.Request
+	// RequestBody is the body of the request.
+	RequestBody []byte
+	// RequestID is the id that correlates the request and the event.
+	RequestID string
+	// Method is the method of the request.
+	Method string
+	// Path is the request path without the path prefix.
+	Path string
+	// Claims are the claims of the bearer token, set when the source has a JWTAuth.
+	Claims map[string]any
+
+	// Status is the status code of the response.
+	Status int
+	// ResponseHeader holds the headers of the response.
+	ResponseHeader http.Header
+	// ContentType is the content type of the response.
+	ContentType string
+	// ResponseBody is the body of the response.
+	ResponseBody []byte
+}
+
+// EventBuilder builds the event for a handled request.
+type EventBuilder interface {
+	BuildEvent(ctx context.Context, info *EventInfo) (*cloudevents.Event, error)
+}
+
+// EventBuilderFunc is a function that implements EventBuilder.
+type EventBuilderFunc func(ctx context.Context, info *EventInfo) (*cloudevents.Event, error)
+
+func (f EventBuilderFunc) BuildEvent(ctx context.Context, info *EventInfo) (*cloudevents.Event, error) {
+	return f(ctx, info)
+}
+
+// EventMutator changes the built event before it is sent.
+// Mutators run in the order in which they are registered.
+type EventMutator interface {
+	MutateEvent(ctx context.Context, evt *cloudevents.Event, info *EventInfo) error
+}
+
+// EventMutatorFunc is a function that implements EventMutator.
+type EventMutatorFunc func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) error
+
+func (f EventMutatorFunc) MutateEvent(ctx context.Context, evt *cloudevents.Event, info *EventInfo) error {
+	return f(ctx, evt, info)
+}
+
+// EventFilter decides if an event is sent.
+// The filters run after the mutators, the event is dropped when a filter returns false.
+type EventFilter interface {
+	FilterEvent(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool
+}
+
+// EventFilterFunc is a function that implements EventFilter.
+type EventFilterFunc func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool
+
+func (f EventFilterFunc) FilterEvent(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool {
+	return f(ctx, evt, info)
+}
+
+// DefaultEventBuilder builds the events of a Source when no builder is set.
+//
+// The id is a new UUID, the type is the type prefix followed by the
+// lowercase method and _handled, the subject is the path, and the data is
+// set as configured in Data, the response body by default.
+type DefaultEventBuilder struct {
+	Source     string
+	TypePrefix string
+	Data       EventData
+}
+
+func (b *DefaultEventBuilder) BuildEvent(ctx context.Context, info *EventInfo) (*cloudevents.Event, error) {
+	const typeSuffix = "_handled"
+
+	evt := cloudevents.NewEvent()
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	evt.SetID(id.String())
+	evt.SetSource(b.Source)
+	evt.SetType(b.TypePrefix + "." + strings.ToLower(info.Method) + typeSuffix)
+	evt.SetSubject(info.Path)
+
+	// Set the data
+	if err := b.Data.setData(&evt, info); err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
+
+// eventBuilder returns the configured builder or a DefaultEventBuilder.
+func (s *Source) eventBuilder() EventBuilder {
+	if s.builder != nil {
+		return s.builder
+	}
+	return &DefaultEventBuilder{
+		Source:     s.source,
+		TypePrefix: s.typePrefix,
+		Data:       s.eventData,
+	}
+}
@@ -0,0 +1,122 @@
+package cewrap
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHooks(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("Hi there"))
+	}))
+	defer svr.Close()
+
+	builder := EventBuilderFunc(func(ctx context.Context, info *EventInfo) (*cloudevents.Event, error) {
+		evt := cloudevents.NewEvent()
+		evt.SetSource("urn:custom")
+		evt.SetType("custom." + info.Method)
+		evt.SetSubject(info.Path)
+		evt.SetExtension("etag", info.ResponseHeader.Get("ETag"))
+		return &evt, nil
+	})
+	var order []string
+	mutator := func(name string) EventMutator {
+		return EventMutatorFunc(func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) error {
+			order = append(order, name)
+			evt.SetExtension("mutated", name)
+			return nil
+		})
+	}
+	filter := EventFilterFunc(func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool {
+		return info.Path != "/skip"
+	})
+
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	s, err := NewSourceE(
+		WithDownstream(svr.URL),
+		WithSink(sink),
+		WithEventBuilder(builder),
+		WithEventMutators(mutator("one"), mutator("two")),
+		WithEventFilters(filter),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/persons/1", bytes.NewBufferString("Hallo daar")))
+
+	var evt cloudevents.Event
+	select {
+	case evt = <-echan:
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+	assert.Equal(t, "custom.PUT", evt.Type())
+	assert.NotEmpty(t, evt.ID())
+	assert.Equal(t, `"v1"`, evt.Extensions()["etag"])
+	assert.Equal(t, "two", evt.Extensions()["mutated"])
+	assert.Equal(t, []string{"one", "two"}, order)
+	assert.Equal(t, rr.Header().Get(DefaultRequestIDHeader), evt.Extensions()[RequestIDExtension])
+
+	// The filter vetoes the event.
+	rr = httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/skip", bytes.NewBufferString("Hallo daar")))
+	select {
+	case evt = <-echan:
+		t.Errorf("event not filtered: %v", evt)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEmitEvent(t *testing.T) {
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithSink(sink),
+		WithSource("urn:test"),
+		WithTypePrefix("com.example"),
+		WithEventMutators(EventMutatorFunc(func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) error {
+			evt.SetExtension("team", "crm")
+			return nil
+		})),
+		WithCEOverrides(&CEOverrides{Extensions: map[string]string{"cluster": "prod"}}),
+		WithEventFilters(EventFilterFunc(func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool {
+			return evt.Subject() != "dropped"
+		})),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, s.EmitEvent(context.Background(), "config_reloaded", "config.yaml", map[string]any{"config": "config.yaml"}))
+	select {
+	case evt := <-echan:
+		assert.Equal(t, "com.example.config_reloaded", evt.Type())
+		assert.Equal(t, "config.yaml", evt.Subject())
+		assert.Equal(t, "crm", evt.Extensions()["team"])
+		assert.Equal(t, "prod", evt.Extensions()["cluster"])
+		assert.NotContains(t, evt.Extensions(), RequestIDExtension)
+		assert.JSONEq(t, `{"config":"config.yaml"}`, string(evt.Data()))
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+
+	// The filters can veto the event.
+	assert.NoError(t, s.EmitEvent(context.Background(), "config_reloaded", "dropped", nil))
+	select {
+	case evt := <-echan:
+		t.Fatalf("unexpected event %s", evt.Subject())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,229 @@
+package cewrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// maxExtensionNameLength is the max length of an extension name recommended
+// by the CloudEvents spec. Longer names are valid, but some systems may not
+// support them.
+const maxExtensionNameLength = 20
+
+// reservedAttributes are the context attributes that are not extensions.
+var reservedAttributes = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"source":          true,
+	"type":            true,
+	"subject":         true,
+	"time":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"data":            true,
+	"data_base64":     true,
+}
+
+// ValidateExtensionName checks that name is a valid CloudEvents extension
+// name: lowercase letters and digits, and not the name of a context attribute.
+// The Source logs a warning for the names longer than the 20 characters
+// that the spec recommends.
+func ValidateExtensionName(name string) error {
+	if name == "" {
+		return errors.New("extension name is empty")
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("extension name %q must only contain lowercase letters and digits", name)
+		}
+	}
+	if reservedAttributes[name] {
+		return fmt.Errorf("extension name %q is a context attribute", name)
+	}
+	return nil
+}
+
+// warnExtensionName logs a warning when name is longer than recommended.
+func warnExtensionName(logger *slog.Logger, name string) {
+	if len(name) > maxExtensionNameLength {
+		logger.Warn("extension name is longer than recommended",
+			slog.String("extension", name),
+			slog.Int("max", maxExtensionNameLength),
+		)
+	}
+}
+
+// ExtensionFrom is where the value of a mapped extension comes from.
+type ExtensionFrom string
+
+const (
+	FromRequestHeader  ExtensionFrom = "requestHeader"
+	FromResponseHeader ExtensionFrom = "responseHeader"
+	FromQuery          ExtensionFrom = "query"
+	FromPath           ExtensionFrom = "path"
+	FromStatus         ExtensionFrom = "status"
+	// FromClientCert is the identity of the verified client certificate,
+	// Key is "subject" for the subject DN or "san" for the first subject
+	// alternative name: the URI, DNS name, email address or IP address.
+	FromClientCert ExtensionFrom = "clientCert"
+	// FromClaim is a claim of the bearer token, see WithJWTAuth.
+	// Key is the name of the claim, the elements of an array are joined with a comma.
+	FromClaim ExtensionFrom = "claim"
+)
+
+// ExtensionMapping sets an extension from the request or the response.
+type ExtensionMapping struct {
+	// Extension is the name of the extension.
+	Extension string
+	// From is where the value comes from.
+	From ExtensionFrom
+	// Key is the name of the header, the query parameter, the path parameter or the
+	// claim, or the identity field for FromClientCert. It is not used for FromStatus.
+	Key string
+	// Pattern is the path template for FromPath, like "/persons/{id}".
+	// A {name} segment matches any segment, a * segment matches the rest of the path.
+	Pattern string
+}
+
+// validate checks the mapping.
+func (m *ExtensionMapping) validate() error {
+	if err := ValidateExtensionName(m.Extension); err != nil {
+		return err
+	}
+	switch m.From {
+	case FromRequestHeader, FromResponseHeader, FromQuery, FromClaim:
+		if m.Key == "" {
+			return fmt.Errorf("extension %s: key is not set", m.Extension)
+		}
+	case FromPath:
+		if m.Key == "" {
+			return fmt.Errorf("extension %s: key is not set", m.Extension)
+		}
+		if !strings.Contains(m.Pattern, "{"+m.Key+"}") {
+			return fmt.Errorf("extension %s: pattern %q has no {%s} segment", m.Extension, m.Pattern, m.Key)
+		}
+	case FromClientCert:
+		if m.Key != "subject" && m.Key != "san" {
+			return fmt.Errorf("extension %s: key %q is not subject or san", m.Extension, m.Key)
+		}
+	case FromStatus:
+	default:
+		return fmt.Errorf("extension %s: unknown source %q", m.Extension, m.From)
+	}
+	return nil
+}
+
+// value returns the value of the mapping for info, nil when there is none.
+func (m *ExtensionMapping) value(info *EventInfo) any {
+	var v string
+	switch m.From {
+	case FromRequestHeader:
+		if info.Request != nil {
+			v = info.Request.Header.Get(m.Key)
+		}
+	case FromResponseHeader:
+		v = info.ResponseHeader.Get(m.Key)
+	case FromQuery:
+		if info.Request != nil {
+			v = info.Request.URL.Query().Get(m.Key)
+		}
+	case FromPath:
+		v = pathParams(m.Pattern, info.Path)[m.Key]
+	case FromClientCert:
+		v = clientIdentity(info.Request, m.Key)
+	case FromClaim:
+		v = claimString(info.Claims[m.Key])
+	case FromStatus:
+		if info.Status == 0 {
+			return nil
+		}
+		return int32(info.Status)
+	}
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// ExtensionMapper returns a mutator that sets the extensions of the mappings.
+// An extension without a value is not set.
+func ExtensionMapper(mappings ...ExtensionMapping) (EventMutator, error) {
+	var errs []error
+	for i := range mappings {
+		if err := mappings[i].validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return extensionMapper(mappings), nil
+}
+
+// extensionMapper is the mutator of ExtensionMapper.
+type extensionMapper []ExtensionMapping
+
+func (em extensionMapper) MutateEvent(ctx context.Context, evt *cloudevents.Event, info *EventInfo) error {
+	for i := range em {
+		if v := em[i].value(info); v != nil {
+			evt.SetExtension(em[i].Extension, v)
+		}
+	}
+	return nil
+}
+
+// pathParams matches path against the template pattern and returns the
+// values of the {name} segments, nil when the path does not match.
+func pathParams(pattern, path string) map[string]string {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	ss := strings.Split(strings.Trim(path, "/"), "/")
+	params := map[string]string{}
+	for i, p := range ps {
+		if p == "*" && i == len(ps)-1 {
+			return params
+		}
+		if i >= len(ss) {
+			return nil
+		}
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			params[p[1:len(p)-1]] = ss[i]
+			continue
+		}
+		if p != ss[i] {
+			return nil
+		}
+	}
+	if len(ps) != len(ss) {
+		return nil
+	}
+	return params
+}
+
+// clientIdentity returns the subject DN or the first subject alternative
+// name of the verified client certificate of r, empty when there is none.
+func clientIdentity(r *http.Request, field string) string {
+	if r == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if field == "subject" {
+		return cert.Subject.String()
+	}
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.IPAddresses) > 0:
+		return cert.IPAddresses[0].String()
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package cewrap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateExtensionName(t *testing.T) {
+	assert.NoError(t, ValidateExtensionName("tenant"))
+	assert.NoError(t, ValidateExtensionName("httpstatus2"))
+	assert.Error(t, ValidateExtensionName(""))
+	assert.Error(t, ValidateExtensionName("Tenant"))
+	assert.Error(t, ValidateExtensionName("tenant_id"))
+	// Longer than recommended, but valid.
+	assert.NoError(t, ValidateExtensionName("averyveryverylongextension"))
+	assert.Error(t, ValidateExtensionName("subject"))
+}
+
+func TestExtensionMapper(t *testing.T) {
+	m, err := ExtensionMapper(
+		ExtensionMapping{Extension: "tenant", From: FromRequestHeader, Key: "X-Tenant"},
+		ExtensionMapping{Extension: "etag", From: FromResponseHeader, Key: "ETag"},
+		ExtensionMapping{Extension: "location", From: FromResponseHeader, Key: "Content-Location"},
+		ExtensionMapping{Extension: "dryrun", From: FromQuery, Key: "dryRun"},
+		ExtensionMapping{Extension: "personid", From: FromPath, Key: "id", Pattern: "/persons/{id}/*"},
+		ExtensionMapping{Extension: "httpstatus", From: FromStatus},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/persons/42/address?dryRun=true", nil)
+	req.Header.Set("X-Tenant", "acme")
+	h := http.Header{}
+	h.Set("ETag", `"v2"`)
+	info := &EventInfo{Request: req, Path: "/persons/42/address", Status: http.StatusOK, ResponseHeader: h}
+
+	evt := cloudevents.NewEvent()
+	assert.NoError(t, m.MutateEvent(context.Background(), &evt, info))
+	ext := evt.Extensions()
+	assert.Equal(t, "acme", ext["tenant"])
+	assert.Equal(t, `"v2"`, ext["etag"])
+	assert.Equal(t, "true", ext["dryrun"])
+	assert.Equal(t, "42", ext["personid"])
+	assert.Equal(t, int32(200), ext["httpstatus"])
+	assert.NotContains(t, ext, "location")
+
+	_, err = ExtensionMapper(
+		ExtensionMapping{Extension: "Tenant", From: FromRequestHeader, Key: "X-Tenant"},
+		ExtensionMapping{Extension: "id2", From: FromPath, Key: "id", Pattern: "/persons/*"},
+		ExtensionMapping{Extension: "x", From: "cookie", Key: "x"},
+	)
+	assert.ErrorContains(t, err, "lowercase")
+	assert.ErrorContains(t, err, "has no {id} segment")
+	assert.ErrorContains(t, err, `unknown source "cookie"`)
+}
+
+func TestPathParams(t *testing.T) {
+	assert.Equal(t, map[string]string{"id": "1"}, pathParams("/persons/{id}", "/persons/1"))
+	assert.Nil(t, pathParams("/persons/{id}", "/persons/1/address"))
+	assert.Nil(t, pathParams("/persons/{id}", "/orders/1"))
+	assert.Equal(t, map[string]string{"id": "1", "line": "2"}, pathParams("/orders/{id}/lines/{line}", "/orders/1/lines/2"))
+}
+
+func TestClientIdentity(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "orders", Organization: []string{"acme"}},
+		DNSNames: []string{"orders.acme.example.com"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/persons", nil)
+	m, err := ExtensionMapper(
+		ExtensionMapping{Extension: "clientdn", From: FromClientCert, Key: "subject"},
+		ExtensionMapping{Extension: "clientsan", From: FromClientCert, Key: "san"},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Without a verified certificate there is no identity.
+	evt := cloudevents.NewEvent()
+	assert.NoError(t, m.MutateEvent(context.Background(), &evt, &EventInfo{Request: req}))
+	assert.Empty(t, evt.Extensions())
+
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	assert.NoError(t, m.MutateEvent(context.Background(), &evt, &EventInfo{Request: req}))
+	assert.Equal(t, "CN=orders,O=acme", evt.Extensions()["clientdn"])
+	assert.Equal(t, "orders.acme.example.com", evt.Extensions()["clientsan"])
+
+	_, err = ExtensionMapper(ExtensionMapping{Extension: "clientid", From: FromClientCert, Key: "cn"})
+	assert.ErrorContains(t, err, `key "cn" is not subject or san`)
+}
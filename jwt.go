@@ -0,0 +1,404 @@
+package cewrap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// DefaultJWKSRefreshInterval is the interval for fetching the key set from the url again.
+	DefaultJWKSRefreshInterval = 15 * time.Minute
+	// jwksMinRefreshInterval limits fetching the key set for unknown key ids.
+	jwksMinRefreshInterval = time.Minute
+	// jwksRetryInterval is the time after a failed load before the set is loaded again.
+	jwksRetryInterval = 10 * time.Second
+)
+
+// JWKS is a JSON Web Key Set with the keys that verify the tokens.
+//
+// The set is read from a file, which is read again when it changes,
+// or fetched from a url, which is fetched again after the refresh interval
+// and, at most once a minute, when a token has an unknown key id.
+//
+// The set is loaded on first use. It is loaded by one caller at a time
+// without blocking the callers that can use the current keys, and after
+// a failure it is not loaded again for jwksRetryInterval.
+type JWKS struct {
+	// File holds the key set.
+	File string
+	// URL serves the key set, used when File is not set.
+	URL string
+	// RefreshInterval for the url, DefaultJWKSRefreshInterval when 0.
+	RefreshInterval time.Duration
+	// Client fetches the url, a client with a 10s timeout when nil.
+	Client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]any
+	mod     time.Time
+	fetched time.Time
+	// Time and error of the last load.
+	attempt time.Time
+	err     error
+	// Closed when the load in progress is done, nil when none is.
+	loading chan struct{}
+}
+
+// jwk is a JSON Web Key, only the public RSA, EC and OKP keys are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses the key set in b and returns the keys by key id.
+// Keys that are not for signatures or of an unsupported type or curve are skipped.
+func ParseJWKS(b []byte) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+	keys := map[string]any{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signature keys in key set")
+	}
+	return keys, nil
+}
+
+// publicKey returns the public key, nil for unsupported key types and curves.
+func (k *jwk) publicKey() (any, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %w", err)
+		}
+		e, err := b64(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid e: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := b64(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid x")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// errKeysUnavailable is returned when the key set has not been loaded yet.
+var errKeysUnavailable = errors.New("key set not available")
+
+// Load reads or fetches the key set now.
+func (k *JWKS) Load() error {
+	k.mu.Lock()
+	ch := k.startLoad()
+	k.mu.Unlock()
+	if ch == nil {
+		// Another load is in progress, wait for it.
+		k.mu.Lock()
+		ch = k.loading
+		k.mu.Unlock()
+	}
+	if ch != nil {
+		<-ch
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.err
+}
+
+// startLoad starts loading the set in the background when no load is in
+// progress and returns the channel that is closed when it is done, nil when
+// a load is already in progress. It is called with k.mu held.
+func (k *JWKS) startLoad() chan struct{} {
+	if k.loading != nil {
+		return nil
+	}
+	ch := make(chan struct{})
+	k.loading = ch
+	k.attempt = time.Now()
+	go k.load(ch)
+	return ch
+}
+
+// load reads or fetches the set without holding k.mu and swaps in the keys.
+// When it fails the previous keys are kept.
+func (k *JWKS) load(done chan struct{}) {
+	var (
+		b   []byte
+		err error
+		mod time.Time
+	)
+	if k.File != "" {
+		mod = fileModTime(k.File)
+		b, err = os.ReadFile(k.File)
+	} else {
+		b, err = k.fetch()
+	}
+	var keys map[string]any
+	if err != nil {
+		err = fmt.Errorf("error loading key set: %w", err)
+	} else {
+		keys, err = ParseJWKS(b)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// The file is not read again until it changes, also when it is invalid.
+	k.mod = mod
+	k.err = err
+	if err == nil {
+		k.keys, k.fetched = keys, time.Now()
+	}
+	k.loading = nil
+	close(done)
+}
+
+func (k *JWKS) fetch() ([]byte, error) {
+	c := k.Client
+	if c == nil {
+		c = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := c.Get(k.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// stale reports whether the set must be loaded again for kid.
+// It is called with k.mu held.
+func (k *JWKS) stale(kid string) bool {
+	if k.err != nil && time.Since(k.attempt) < jwksRetryInterval {
+		return false
+	}
+	if k.File != "" {
+		return k.keys == nil && k.err == nil || !fileModTime(k.File).Equal(k.mod)
+	}
+	interval := k.RefreshInterval
+	if interval == 0 {
+		interval = DefaultJWKSRefreshInterval
+	}
+	age := time.Since(k.fetched)
+	_, known := k.keys[kid]
+	return k.keys == nil || age > interval || !known && age > jwksMinRefreshInterval
+}
+
+// key returns the key for kid. It loads the set again when it is stale.
+// The callers only wait for the load when the current keys do not have kid,
+// and at most until ctx is done.
+func (k *JWKS) key(ctx context.Context, kid string) (any, error) {
+	k.mu.Lock()
+	var ch chan struct{}
+	if k.stale(kid) {
+		k.startLoad()
+	}
+	if _, ok := k.lookup(kid); !ok {
+		ch = k.loading
+	}
+	k.mu.Unlock()
+
+	if ch != nil {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	if k.keys == nil {
+		if k.err != nil {
+			return nil, fmt.Errorf("%w: %w", errKeysUnavailable, k.err)
+		}
+		return nil, errKeysUnavailable
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// lookup returns the key for kid from the current keys.
+// It is called with k.mu held.
+func (k *JWKS) lookup(kid string) (any, bool) {
+	if key, ok := k.keys[kid]; ok {
+		return key, true
+	}
+	// A set with a single key is used for tokens without a key id.
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// JWTAuth checks the bearer JWTs of the incoming requests. The claims of
+// the token are available to the mutators in EventInfo.Claims.
+type JWTAuth struct {
+	// Keys verify the tokens. When nil the tokens are not validated and the
+	// claims are only extracted, for tokens checked by a gateway in front.
+	Keys *JWKS
+	// Issuer is the required iss claim, not checked when empty.
+	Issuer string
+	// Audience is the required aud claim, not checked when empty.
+	Audience string
+	// Optional passes requests without a token, without claims. Else they
+	// are rejected, also when the claims are only extracted.
+	Optional bool
+	// Leeway for the time based claims.
+	Leeway time.Duration
+}
+
+// errNoToken is returned for a request without a bearer token.
+var errNoToken = errors.New("no bearer token")
+
+// validMethods are the accepted signing algorithms, only asymmetric ones.
+var validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// bearerToken returns the bearer token of r, empty when there is none.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// authenticate returns the claims of the token of r.
+// It returns an error when the request must be rejected.
+func (a *JWTAuth) authenticate(r *http.Request) (jwt.MapClaims, error) {
+	token := bearerToken(r)
+	if token == "" {
+		if a.Optional {
+			return nil, nil
+		}
+		return nil, errNoToken
+	}
+	claims := jwt.MapClaims{}
+	if a.Keys == nil {
+		// The token has been validated before, a token that cannot be parsed has no claims.
+		if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+			return nil, nil
+		}
+		return claims, nil
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(validMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(a.Leeway),
+	}
+	if a.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.Issuer))
+	}
+	if a.Audience != "" {
+		opts = append(opts, jwt.WithAudience(a.Audience))
+	}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.Keys.key(r.Context(), kid)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// claimString returns the claim as a string, the elements of an array
+// are separated by a comma. It returns empty for a missing claim.
+func claimString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []any:
+		s := make([]string, 0, len(v))
+		for _, e := range v {
+			s = append(s, claimString(e))
+		}
+		return strings.Join(s, ",")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// fileModTime returns the modification time of name, the zero time when it cannot be read.
+func fileModTime(name string) time.Time {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
@@ -0,0 +1,348 @@
+package cewrap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKey is a signing key with its JWK.
+type testKey struct {
+	kid string
+	key *ecdsa.PrivateKey
+}
+
+func newTestKey(t *testing.T, kid string) *testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{kid: kid, key: key}
+}
+
+func (k *testKey) jwk() map[string]string {
+	b64 := func(i *big.Int) string {
+		b := make([]byte, 32)
+		return base64.RawURLEncoding.EncodeToString(i.FillBytes(b))
+	}
+	return map[string]string{
+		"kty": "EC", "kid": k.kid, "use": "sig", "alg": "ES256", "crv": "P-256",
+		"x": b64(k.key.X), "y": b64(k.key.Y),
+	}
+}
+
+func jwks(keys ...*testKey) []byte {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.jwk())
+	}
+	b, _ := json.Marshal(set)
+	return b
+}
+
+func (k *testKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["kid"] = k.kid
+	s, err := tok.SignedString(k.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWTAuth(t *testing.T) {
+	key := newTestKey(t, "k1")
+	keyFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(keyFile, jwks(key), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, echan := test.NewMockSenderClient(t, 10, client.WithUUIDs(), client.WithTimeNow())
+	mapper, err := ExtensionMapper(
+		ExtensionMapping{Extension: "actor", From: FromClaim, Key: "sub"},
+		ExtensionMapping{Extension: "tenant", From: FromClaim, Key: "tenant"},
+		ExtensionMapping{Extension: "roles", From: FromClaim, Key: "roles"},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithSink(sink),
+		WithSource("urn:test"),
+		WithEventMutators(mapper),
+		WithJWTAuth(&JWTAuth{
+			Keys:     &JWKS{File: keyFile},
+			Issuer:   "https://login.example.com",
+			Audience: "crm",
+		}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func(token string) int {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/persons", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	claims := func(mod func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":    "https://login.example.com",
+			"aud":    "crm",
+			"sub":    "alice",
+			"tenant": "acme",
+			"roles":  []string{"admin", "user"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+
+	assert.Equal(t, http.StatusCreated, post(key.sign(t, claims(nil))))
+	select {
+	case evt := <-echan:
+		assert.Equal(t, "alice", evt.Extensions()["actor"])
+		assert.Equal(t, "acme", evt.Extensions()["tenant"])
+		assert.Equal(t, "admin,user", evt.Extensions()["roles"])
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+
+	// Invalid tokens are rejected before the request is handled.
+	other := newTestKey(t, "k1")
+	for name, token := range map[string]string{
+		"no token":       "",
+		"not a jwt":      "abc",
+		"expired":        key.sign(t, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() })),
+		"no expiry":      key.sign(t, claims(func(c jwt.MapClaims) { delete(c, "exp") })),
+		"wrong issuer":   key.sign(t, claims(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })),
+		"wrong audience": key.sign(t, claims(func(c jwt.MapClaims) { c["aud"] = "billing" })),
+		"wrong key":      other.sign(t, claims(nil)),
+	} {
+		assert.Equal(t, http.StatusUnauthorized, post(token), name)
+	}
+	select {
+	case evt := <-echan:
+		t.Fatalf("unexpected event %s", evt.ID())
+	default:
+	}
+
+	// A rotated key set is read again.
+	k2 := newTestKey(t, "k2")
+	if err := os.WriteFile(keyFile, jwks(key, k2), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	assert.Equal(t, http.StatusCreated, post(k2.sign(t, claims(nil))))
+}
+
+func TestJWTAuthExtractOnly(t *testing.T) {
+	key := newTestKey(t, "k1")
+	a := &JWTAuth{}
+	req := httptest.NewRequest(http.MethodPost, "/persons", nil)
+
+	// A token is required.
+	_, err := a.authenticate(req)
+	assert.ErrorIs(t, err, errNoToken)
+
+	// Optional passes requests without a token, without claims.
+	a.Optional = true
+	c, err := a.authenticate(req)
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	// The token is not validated, e.g. an expired token still has claims.
+	req.Header.Set("Authorization", "Bearer "+key.sign(t, jwt.MapClaims{
+		"client_id": "orders",
+		"exp":       time.Now().Add(-time.Hour).Unix(),
+	}))
+	c, err = a.authenticate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "orders", claimString(c["client_id"]))
+
+	// Optional passes requests without a token.
+	a = &JWTAuth{Keys: &JWKS{URL: "http://example.com/jwks"}, Optional: true}
+	c, err = a.authenticate(httptest.NewRequest(http.MethodPost, "/persons", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestJWKSURL(t *testing.T) {
+	k1, k2 := newTestKey(t, "k1"), newTestKey(t, "k2")
+	set := jwks(k1)
+	fetches := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(set)
+	}))
+	defer svr.Close()
+
+	keys := &JWKS{URL: svr.URL, RefreshInterval: time.Hour}
+	assert.NoError(t, keys.Load())
+	_, err := keys.key(context.Background(), "k1")
+	assert.NoError(t, err)
+	_, err = keys.key(context.Background(), "k2")
+	assert.ErrorContains(t, err, `unknown key id "k2"`)
+	assert.Equal(t, 1, fetches)
+
+	// The set is fetched again after the refresh interval.
+	set = jwks(k1, k2)
+	keys.RefreshInterval = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	_, err = keys.key(context.Background(), "k2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+
+	_, err = ParseJWKS([]byte(`{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`))
+	assert.ErrorContains(t, err, "no signature keys")
+	// Keys with an unsupported curve are skipped.
+	ks, err := ParseJWKS([]byte(`{"keys": [
+		{"kty": "EC", "kid": "k1", "crv": "P-256K", "x": "AA", "y": "AA"},
+		{"kty": "OKP", "kid": "k2", "crv": "X25519", "x": "AA"},
+		{"kty": "OKP", "kid": "k3", "crv": "Ed25519", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}
+	]}`))
+	if assert.NoError(t, err) {
+		assert.Len(t, ks, 1)
+		assert.Contains(t, ks, "k3")
+	}
+	_, err = NewSourceE(WithMiddlewareMode(), WithJWTAuth(&JWTAuth{Audience: "crm"}))
+	assert.ErrorContains(t, err, "issuer and audience need keys")
+}
+
+func TestJWKSURLRefresh(t *testing.T) {
+	k1 := newTestKey(t, "k1")
+	var (
+		fetches atomic.Int32
+		fail    atomic.Bool
+	)
+	block := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if fail.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		<-block
+		w.Write(jwks(k1))
+	}))
+	defer svr.Close()
+	ctx := context.Background()
+	expire := func(keys *JWKS) {
+		keys.mu.Lock()
+		keys.fetched = time.Now().Add(-2 * time.Hour)
+		keys.mu.Unlock()
+	}
+
+	// The first token waits for the keys.
+	keys := &JWKS{URL: svr.URL, RefreshInterval: time.Hour}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		block <- struct{}{}
+	}()
+	_, err := keys.key(ctx, "k1")
+	assert.NoError(t, err)
+
+	// A refresh does not block the tokens with a known key.
+	expire(keys)
+	done := make(chan error)
+	go func() {
+		_, err := keys.key(ctx, "k1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("key blocked by the refresh")
+	}
+	block <- struct{}{}
+	assert.NoError(t, keys.Load())
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// A failed refresh keeps the keys and is not retried for a while.
+	fail.Store(true)
+	expire(keys)
+	assert.ErrorContains(t, keys.Load(), "unexpected status 503")
+	for i := 0; i < 10; i++ {
+		_, err := keys.key(ctx, "k1")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), fetches.Load())
+
+	// Without keys the tokens fail with errKeysUnavailable, also without retries.
+	keys = &JWKS{URL: svr.URL}
+	_, err = keys.key(ctx, "k1")
+	assert.ErrorIs(t, err, errKeysUnavailable)
+	_, err = keys.key(ctx, "k1")
+	assert.ErrorIs(t, err, errKeysUnavailable)
+	assert.Equal(t, int32(4), fetches.Load())
+}
+
+func TestJWTAuthFailClosed(t *testing.T) {
+	handler := func(s *Source) int {
+		h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/persons", nil)
+		req.Header.Set("Authorization", "Bearer "+newTestKey(t, "k1").sign(t, jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}))
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// A key set that is not available at the start does not fail the source,
+	// the requests are rejected until it is.
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer svr.Close()
+	s, err := NewSourceE(WithMiddlewareMode(), WithJWTAuth(&JWTAuth{Keys: &JWKS{URL: svr.URL}}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusServiceUnavailable, handler(s))
+	}
+
+	// NewSource does not drop invalid security options.
+	for name, opt := range map[string]SourceOption{
+		"jwt":      WithJWTAuth(&JWTAuth{Audience: "crm"}),
+		"access":   WithAccessControl(&AccessControl{Status: 401}),
+		"redactor": WithRedactor(&Redactor{Rules: []RedactRule{{Action: "scramble"}}}),
+	} {
+		assert.Equal(t, http.StatusServiceUnavailable, handler(NewSource(WithMiddlewareMode(), opt)), name)
+	}
+}
+
+func TestClaimString(t *testing.T) {
+	assert.Equal(t, "", claimString(nil))
+	assert.Equal(t, "alice", claimString("alice"))
+	assert.Equal(t, "a,b", claimString([]any{"a", "b"}))
+	assert.Equal(t, "42", claimString(float64(42)))
+	assert.Equal(t, "true", claimString(true))
+}
@@ -0,0 +1,40 @@
+package cewrap
+
+import (
+	"context"
+	"log/slog"
+)
+
+// sampleLogger returns logger when the current request is sampled,
+// otherwise a logger that only passes warnings and errors.
+func (s *Source) sampleLogger(logger *slog.Logger) *slog.Logger {
+	if s.logSampling <= 1 {
+		return logger
+	}
+	if (s.logSampleCount.Add(1)-1)%s.logSampling == 0 {
+		return logger
+	}
+	return slog.New(&minLevelHandler{h: logger.Handler(), min: slog.LevelWarn})
+}
+
+// minLevelHandler drops the records below min.
+type minLevelHandler struct {
+	h   slog.Handler
+	min slog.Level
+}
+
+func (m *minLevelHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= m.min && m.h.Enabled(ctx, l)
+}
+
+func (m *minLevelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return m.h.Handle(ctx, r)
+}
+
+func (m *minLevelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &minLevelHandler{h: m.h.WithAttrs(attrs), min: m.min}
+}
+
+func (m *minLevelHandler) WithGroup(name string) slog.Handler {
+	return &minLevelHandler{h: m.h.WithGroup(name), min: m.min}
+}
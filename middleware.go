@@ -0,0 +1,122 @@
+package cewrap
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Middleware returns a handler that passes the requests to next in-process
+// and emits the same events as the proxy, without a network hop.
+//
+// The status, headers and body that next writes are captured to build the event.
+// Use WithMiddlewareMode to create a source without a downstream.
+func (s *Source) Middleware(next http.Handler) http.Handler {
+	return s.handler(next)
+}
+
+// callNext passes the request to next and saves the event data.
+func (s *serviceRequest) callNext(sw *statusWriter, r *http.Request, next http.Handler) {
+	emit := s.s.isEmitEvent(r.Method)
+
+	// Count the request body, like buildDownstreamRequest does for the proxy,
+	// and keep a copy for the event.
+	var cr *countingReader
+	if r.Body != nil {
+		cr = &countingReader{ReadCloser: r.Body, capture: emit}
+		r.Body = cr
+		defer func() { s.bytesIn = cr.n }()
+	}
+
+	sw.capture = emit
+	start := time.Now()
+	next.ServeHTTP(sw, r)
+	s.downstreamLatency = time.Since(start)
+	sw.finish()
+
+	if !emit {
+		return
+	}
+
+	// Save event data.
+	s.responseBody = sw.body.Bytes()
+	s.contentType = sw.Header().Get("content-type")
+	s.status = sw.status
+	s.responseHeader = sw.Header()
+	s.request = r
+	if cr != nil {
+		s.requestBody = cr.body.Bytes()
+	}
+	s.saveRequestData(r)
+}
+
+// statusWriter passes the response to the wrapped ResponseWriter and records
+// the status and the number of bytes written, for the access log, CORS and
+// the events. It keeps a copy of the body when capture is set.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+	capture bool
+	body    bytes.Buffer
+	// beforeHeader, when set, changes the headers before they are written.
+	beforeHeader func(h http.Header)
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.setStatus(status)
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.capture {
+		sw.body.Write(b)
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.written += int64(n)
+	return n, err
+}
+
+// finish records 200 OK when the handler wrote nothing, as net/http sends
+// it after the handler returns, and gives beforeHeader its chance.
+func (sw *statusWriter) finish() {
+	if sw.status == 0 {
+		sw.setStatus(http.StatusOK)
+	}
+}
+
+func (sw *statusWriter) setStatus(status int) {
+	sw.status = status
+	if sw.beforeHeader != nil {
+		sw.beforeHeader(sw.Header())
+	}
+}
+
+// Unwrap returns the wrapped ResponseWriter for http.ResponseController.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// countingReader counts the bytes read from the wrapped ReadCloser
+// and keeps a copy of them when capture is set.
+type countingReader struct {
+	io.ReadCloser
+	n       int64
+	capture bool
+	body    bytes.Buffer
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	if c.capture {
+		c.body.Write(p[:n])
+	}
+	return n, err
+}
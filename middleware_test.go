@@ -0,0 +1,107 @@
+package cewrap
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithSink(sink),
+		WithSource("https://testservice.example.com/testapi"),
+		WithTypePrefix("com.example"),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"12345"}`))
+	})
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/persons/12345", bytes.NewBufferString("Hallo daar"))
+	s.Middleware(next).ServeHTTP(rr, req)
+
+	assert.Equal(t, "Hallo daar", got)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, `{"id":"12345"}`, rr.Body.String())
+	assert.NotEmpty(t, rr.Header().Get(DefaultRequestIDHeader))
+
+	var evt cloudevents.Event
+	select {
+	case evt = <-echan:
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+	assert.Equal(t, "com.example.post_handled", evt.Type())
+	assert.Equal(t, "/persons/12345", evt.Subject())
+	assert.Equal(t, cloudevents.ApplicationJSON, evt.DataContentType())
+	assert.Equal(t, `{"id":"12345"}`, string(evt.Data()))
+}
+
+func TestMiddlewareNoWrite(t *testing.T) {
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithSink(sink),
+		WithSource("https://testservice.example.com/testapi"),
+		WithTypePrefix("com.example"),
+		WithEventData(EventData{Mode: DataBoth}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The handler writes nothing, net/http sends 200 OK.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/persons/12345", nil)
+	s.Middleware(next).ServeHTTP(rr, req)
+
+	select {
+	case evt := <-echan:
+		var data struct {
+			Response struct {
+				Status int `json:"status"`
+			} `json:"response"`
+		}
+		assert.NoError(t, evt.DataAs(&data))
+		assert.Equal(t, http.StatusOK, data.Response.Status)
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hi there"))
+	}))
+	defer svr.Close()
+
+	s, err := NewSourceE(WithDownstream(svr.URL))
+	if !assert.NoError(t, err) {
+		return
+	}
+	var h http.Handler = s
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/path", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Hi there", rr.Body.String())
+}
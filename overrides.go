@@ -0,0 +1,39 @@
+package cewrap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// CEOverrides holds the overrides for the events, as defined by the
+// Knative K_CE_OVERRIDES contract of SinkBinding and ContainerSource.
+type CEOverrides struct {
+	// Extensions are set on every event.
+	Extensions map[string]string `json:"extensions,omitempty"`
+}
+
+// ParseCEOverrides parses and validates the JSON document s,
+// for example the value of K_CE_OVERRIDES:
+//
+//	{"extensions": {"cluster": "prod", "team": "crm"}}
+func ParseCEOverrides(s string) (*CEOverrides, error) {
+	o := &CEOverrides{}
+	if err := json.Unmarshal([]byte(s), o); err != nil {
+		return nil, fmt.Errorf("invalid ce overrides: %w", err)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *CEOverrides) validate() error {
+	var errs []error
+	for name := range o.Extensions {
+		if err := ValidateExtensionName(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
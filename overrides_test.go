@@ -0,0 +1,65 @@
+package cewrap
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCEOverrides(t *testing.T) {
+	o, err := ParseCEOverrides(`{"extensions":{"cluster":"prod","team":"crm"}}`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"cluster": "prod", "team": "crm"}, o.Extensions)
+	}
+
+	_, err = ParseCEOverrides(`{"extensions":{"Cluster":"prod"}}`)
+	assert.ErrorContains(t, err, "lowercase")
+
+	_, err = ParseCEOverrides(`{"extensions":`)
+	assert.ErrorContains(t, err, "invalid ce overrides")
+}
+
+func TestSourceCEOverrides(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hi there"))
+	}))
+	defer svr.Close()
+
+	// A name longer than recommended is set, with a warning.
+	o, err := ParseCEOverrides(`{"extensions":{"cluster":"prod","knativeservicerevision":"v2"}}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	logs := &bytes.Buffer{}
+	s, err := NewSourceE(
+		WithDownstream(svr.URL),
+		WithSink(sink),
+		WithSource("urn:test"),
+		WithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+		WithCEOverrides(o),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/persons", bytes.NewBufferString("{}")))
+
+	var evt cloudevents.Event
+	select {
+	case evt = <-echan:
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	assert.Equal(t, "prod", evt.Extensions()["cluster"])
+	assert.Equal(t, "v2", evt.Extensions()["knativeservicerevision"])
+	assert.Contains(t, logs.String(), `"extension name is longer than recommended" extension=knativeservicerevision`)
+}
@@ -0,0 +1,382 @@
+package cewrap
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// LimitBy is what a rate limit counts the requests by.
+type LimitBy string
+
+const (
+	// LimitByRoute counts all requests of the route in one bucket.
+	LimitByRoute LimitBy = "route"
+	// LimitByClientIP counts the requests per client ip address.
+	LimitByClientIP LimitBy = "clientIP"
+	// LimitByHeader counts the requests per value of a header.
+	LimitByHeader LimitBy = "header"
+)
+
+const (
+	// RateLimitedEventType is the type suffix of the events for rejected requests.
+	RateLimitedEventType = "rate_limited"
+
+	// maxBuckets is the max number of buckets of a limit. When there are
+	// this many, the new keys share the overflow bucket.
+	maxBuckets = 10000
+
+	// sweepInterval is the time between the removals of the full buckets.
+	sweepInterval = time.Minute
+)
+
+// RateLimit is a token bucket limit for the requests with a path that
+// matches one of the patterns. A * in a pattern matches any text.
+type RateLimit struct {
+	// Name identifies the limit in the metrics.
+	Name string
+	// Paths the limit applies to, all paths when empty.
+	Paths []string
+	// By is what the requests are counted by, LimitByRoute when empty.
+	By LimitBy
+	// Header for LimitByHeader.
+	Header string
+	// Rate is the number of requests per second.
+	Rate float64
+	// Burst is the size of the bucket, at least 1.
+	Burst int
+
+	set *bucketSet
+}
+
+// bucketSet holds the buckets of a rate limit.
+type bucketSet struct {
+	// The settings of the rate limit the buckets are for.
+	by     LimitBy
+	header string
+	rate   float64
+	burst  int
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	overflow *bucket
+	swept    time.Time
+}
+
+func newBucketSet(rl *RateLimit) *bucketSet {
+	return &bucketSet{
+		by:       rl.By,
+		header:   rl.Header,
+		rate:     rl.Rate,
+		burst:    rl.Burst,
+		buckets:  map[string]*bucket{},
+		overflow: &bucket{lim: rate.NewLimiter(rate.Limit(rl.Rate), rl.Burst)},
+		swept:    time.Now(),
+	}
+}
+
+// fits reports whether the buckets can be used for rl.
+func (bs *bucketSet) fits(rl *RateLimit) bool {
+	return bs.by == rl.By && bs.header == rl.Header && bs.rate == rl.Rate && bs.burst == rl.Burst
+}
+
+// bucket is the limiter for a key.
+type bucket struct {
+	lim  *rate.Limiter
+	seen time.Time
+}
+
+// validate checks the limit.
+func (rl *RateLimit) validate() error {
+	if rl.Rate <= 0 {
+		return fmt.Errorf("rate limit %s: rate must be positive", rl.Name)
+	}
+	if rl.Burst < 1 {
+		return fmt.Errorf("rate limit %s: burst must be at least 1", rl.Name)
+	}
+	switch rl.By {
+	case "", LimitByRoute, LimitByClientIP:
+	case LimitByHeader:
+		if rl.Header == "" {
+			return fmt.Errorf("rate limit %s: header is not set", rl.Name)
+		}
+	default:
+		return fmt.Errorf("rate limit %s: unknown key %q", rl.Name, rl.By)
+	}
+	return nil
+}
+
+// key returns the bucket key of r.
+func (rl *RateLimit) key(r *http.Request) string {
+	switch rl.By {
+	case LimitByClientIP:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	case LimitByHeader:
+		return r.Header.Get(rl.Header)
+	}
+	return ""
+}
+
+// allow takes a token from the bucket of r. When the bucket is empty
+// it returns false and the time until a token is available.
+func (rl *RateLimit) allow(r *http.Request) (bool, time.Duration) {
+	return rl.set.take(rl.key(r), time.Now())
+}
+
+// take takes a token from the bucket of key, see allow.
+func (bs *bucketSet) take(key string, now time.Time) (bool, time.Duration) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if now.Sub(bs.swept) >= sweepInterval {
+		bs.sweep(now)
+		bs.swept = now
+	}
+	b, ok := bs.buckets[key]
+	switch {
+	case ok:
+	case len(bs.buckets) < maxBuckets:
+		b = &bucket{lim: rate.NewLimiter(rate.Limit(bs.rate), bs.burst)}
+		bs.buckets[key] = b
+	default:
+		// Too many keys, e.g. spoofed headers, must not exhaust the memory.
+		b = bs.overflow
+	}
+	b.seen = now
+	res := b.lim.ReserveN(now, 1)
+	if d := res.DelayFrom(now); d > 0 {
+		res.CancelAt(now)
+		return false, d
+	}
+	return true, 0
+}
+
+// sweep removes the buckets that have filled up again, they are the same as new ones.
+func (bs *bucketSet) sweep(now time.Time) {
+	full := time.Duration(float64(bs.burst) / bs.rate * float64(time.Second))
+	for k, b := range bs.buckets {
+		if now.Sub(b.seen) > full {
+			delete(bs.buckets, k)
+		}
+	}
+}
+
+// LimitState holds the buckets of the rate limits and the number of requests
+// in flight. Sources that share it, e.g. the sources before and after a reload
+// of the configuration, share the state, so the clients get no new burst.
+// The buckets of a rate limit are kept while its name, key, rate and burst
+// stay the same.
+type LimitState struct {
+	inFlight atomic.Int64
+
+	mu   sync.Mutex
+	sets map[string]*bucketSet
+}
+
+// bucketSet returns the buckets for rl, new ones when the settings changed.
+func (ls *LimitState) bucketSet(rl *RateLimit) *bucketSet {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	bs := ls.sets[rl.Name]
+	if bs == nil || !bs.fits(rl) {
+		bs = newBucketSet(rl)
+		if ls.sets == nil {
+			ls.sets = map[string]*bucketSet{}
+		}
+		ls.sets[rl.Name] = bs
+	}
+	return bs
+}
+
+// Limits limits the incoming requests before they are handled. Requests over
+// a rate limit get 429 Too Many Requests, requests over the concurrency limit
+// 503 Service Unavailable, both with a Retry-After header.
+type Limits struct {
+	// MaxInFlight is the max number of requests handled at the same time, no limit when 0.
+	MaxInFlight int
+	// RateLimits are the rate limits, the first limit with a matching path applies.
+	RateLimits []*RateLimit
+	// Event emits a rate_limited event for the rejected requests, at most one per second.
+	Event bool
+	// Metrics counts the limited requests, it can be shared by sources. Not counted when nil.
+	Metrics *LimitMetrics
+	// State holds the buckets and the requests in flight, it can be shared by sources.
+	// A new state when nil.
+	State *LimitState
+
+	inFlight *atomic.Int64
+	// Limits the rate_limited events.
+	eventLimiter *rate.Limiter
+}
+
+// init sets up the buckets and the count of the requests in flight, from State when set.
+func (l *Limits) init() {
+	st := l.State
+	if st == nil {
+		st = &LimitState{}
+	}
+	l.inFlight = &st.inFlight
+	for _, rl := range l.RateLimits {
+		rl.set = st.bucketSet(rl)
+	}
+	l.eventLimiter = rate.NewLimiter(1, 1)
+}
+
+// validate checks the limits.
+func (l *Limits) validate() error {
+	var errs []error
+	if l.MaxInFlight < 0 {
+		errs = append(errs, errors.New("max in flight is negative"))
+	}
+	for _, rl := range l.RateLimits {
+		if err := rl.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rateLimit returns the rate limit for path, nil when none applies.
+func (l *Limits) rateLimit(path string) *RateLimit {
+	for _, rl := range l.RateLimits {
+		if len(rl.Paths) == 0 || matchAny(rl.Paths, path) {
+			return rl
+		}
+	}
+	return nil
+}
+
+// limitRejection describes a rejected request.
+type limitRejection struct {
+	limit      string
+	reason     string
+	status     int
+	retryAfter time.Duration
+}
+
+// admit checks the limits for r. It returns a release func that must be
+// called when the request is done, or the rejection.
+func (l *Limits) admit(r *http.Request, path string) (func(), *limitRejection) {
+	if rl := l.rateLimit(path); rl != nil {
+		if ok, wait := rl.allow(r); !ok {
+			return nil, &limitRejection{limit: rl.Name, reason: "rate", status: http.StatusTooManyRequests, retryAfter: wait}
+		}
+	}
+	if l.MaxInFlight > 0 {
+		if l.inFlight.Add(1) > int64(l.MaxInFlight) {
+			l.inFlight.Add(-1)
+			return nil, &limitRejection{limit: "inflight", reason: "concurrency", status: http.StatusServiceUnavailable, retryAfter: time.Second}
+		}
+	}
+	l.Metrics.addInFlight(1)
+	return func() {
+		l.Metrics.addInFlight(-1)
+		if l.MaxInFlight > 0 {
+			l.inFlight.Add(-1)
+		}
+	}, nil
+}
+
+// LimitMetrics counts the limited requests and the requests in flight.
+// A nil LimitMetrics counts nothing.
+type LimitMetrics struct {
+	inFlight atomic.Int64
+
+	mu      sync.Mutex
+	limited map[[2]string]uint64
+}
+
+// LimitedCount is the number of requests that a limit rejected.
+type LimitedCount struct {
+	// Limit is the name of the limit, inflight for the concurrency limit.
+	Limit string
+	// Reason is rate or concurrency.
+	Reason string
+	Count  uint64
+}
+
+func (m *LimitMetrics) addInFlight(n int64) {
+	if m != nil {
+		m.inFlight.Add(n)
+	}
+}
+
+func (m *LimitMetrics) addLimited(limit, reason string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.limited == nil {
+		m.limited = map[[2]string]uint64{}
+	}
+	m.limited[[2]string{limit, reason}]++
+}
+
+// InFlight returns the number of requests in flight.
+func (m *LimitMetrics) InFlight() int64 {
+	return m.inFlight.Load()
+}
+
+// Limited returns the counts of the rejected requests, sorted by limit and reason.
+func (m *LimitMetrics) Limited() []LimitedCount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make([]LimitedCount, 0, len(m.limited))
+	for k, n := range m.limited {
+		counts = append(counts, LimitedCount{Limit: k[0], Reason: k[1], Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Limit != counts[j].Limit {
+			return counts[i].Limit < counts[j].Limit
+		}
+		return counts[i].Reason < counts[j].Reason
+	})
+	return counts
+}
+
+// limit checks the limits for r. It writes the response for a rejected
+// request and returns false, else it returns the release func.
+func (s *serviceRequest) limit(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	l := s.s.limits
+	path := accessPath(r, s.s.pathPrefix)
+	release, rej := l.admit(r, path)
+	if rej == nil {
+		return release, true
+	}
+	l.Metrics.addLimited(rej.limit, rej.reason)
+	s.logger.Warn("request limited",
+		slog.String("limit", rej.limit),
+		slog.String("reason", rej.reason),
+	)
+	secs := int(math.Ceil(rej.retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, http.StatusText(rej.status), rej.status)
+
+	if l.Event {
+		s.emitControlEvent(r, l.eventLimiter, RateLimitedEventType, path, map[string]any{
+			"limit":  rej.limit,
+			"reason": rej.reason,
+			"status": rej.status,
+			"method": r.Method,
+			"path":   path,
+		})
+	}
+	return nil, false
+}
@@ -0,0 +1,233 @@
+package cewrap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimits(t *testing.T) {
+	metrics := &LimitMetrics{}
+	sink, echan := test.NewMockSenderClient(t, 10, client.WithUUIDs(), client.WithTimeNow())
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithSink(sink),
+		WithSource("urn:test"),
+		WithTypePrefix("com.example"),
+		WithChangeMethods([]string{http.MethodPut}),
+		WithLimits(&Limits{
+			RateLimits: []*RateLimit{
+				{Name: "persons", Paths: []string{"/persons*"}, By: LimitByClientIP, Rate: 0.1, Burst: 2},
+				{Name: "tenants", Paths: []string{"/orders*"}, By: LimitByHeader, Header: "X-Tenant", Rate: 0.1, Burst: 1},
+			},
+			Event:   true,
+			Metrics: metrics,
+		}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func(path, ip, tenant string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-Tenant", tenant)
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusCreated, post("/persons", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusCreated, post("/persons/1", "10.0.0.1", "").Code)
+	rr := post("/persons", "10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "10", rr.Header().Get("Retry-After"))
+	// Another client has its own bucket.
+	assert.Equal(t, http.StatusCreated, post("/persons", "10.0.0.2", "").Code)
+
+	assert.Equal(t, http.StatusCreated, post("/orders", "10.0.0.1", "acme").Code)
+	assert.Equal(t, http.StatusTooManyRequests, post("/orders", "10.0.0.2", "acme").Code)
+	assert.Equal(t, http.StatusCreated, post("/orders", "10.0.0.1", "globex").Code)
+
+	// Paths without a limit are not limited.
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusCreated, post("/health", "10.0.0.1", "").Code)
+	}
+
+	assert.Equal(t, []LimitedCount{
+		{Limit: "persons", Reason: "rate", Count: 1},
+		{Limit: "tenants", Reason: "rate", Count: 1},
+	}, metrics.Limited())
+	assert.Equal(t, int64(0), metrics.InFlight())
+
+	// Only one rate_limited event per second.
+	select {
+	case evt := <-echan:
+		assert.Equal(t, "com.example.rate_limited", evt.Type())
+		assert.Equal(t, "/persons", evt.Subject())
+		assert.JSONEq(t, `{"limit":"persons","reason":"rate","status":429,"method":"POST","path":"/persons"}`, string(evt.Data()))
+	case <-time.After(time.Second):
+		t.Fatal("no rate_limited event")
+	}
+	select {
+	case evt := <-echan:
+		t.Fatalf("unexpected event %s", evt.Type())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRateLimitCleanPath(t *testing.T) {
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithLimits(&Limits{
+			RateLimits: []*RateLimit{{Name: "login", Paths: []string{"/login"}, Rate: 0.1, Burst: 1}},
+		}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(path string) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, nil))
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusOK, do("/login"))
+	// The variants of the path share the limit.
+	for _, p := range []string{"/login;x", "//login", "/x/../login"} {
+		assert.Equal(t, http.StatusTooManyRequests, do(p), p)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	metrics := &LimitMetrics{}
+	s, err := NewSourceE(
+		WithMiddlewareMode(),
+		WithLimits(&Limits{MaxInFlight: 1, Metrics: metrics}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	started, done := make(chan struct{}), make(chan struct{})
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-done
+	}))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/persons", nil))
+	<-started
+	assert.Equal(t, int64(1), metrics.InFlight())
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/persons", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	close(done)
+
+	assert.Eventually(t, func() bool { return metrics.InFlight() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []LimitedCount{{Limit: "inflight", Reason: "concurrency", Count: 1}}, metrics.Limited())
+}
+
+func TestLimitsErrors(t *testing.T) {
+	_, err := NewSourceE(WithMiddlewareMode(), WithLimits(&Limits{
+		MaxInFlight: -1,
+		RateLimits: []*RateLimit{
+			{Name: "a", Rate: 0, Burst: 1},
+			{Name: "b", Rate: 1, Burst: 0},
+			{Name: "c", Rate: 1, Burst: 1, By: LimitByHeader},
+			{Name: "d", Rate: 1, Burst: 1, By: "user"},
+		},
+	}))
+	assert.ErrorContains(t, err, "max in flight is negative")
+	assert.ErrorContains(t, err, "rate limit a: rate must be positive")
+	assert.ErrorContains(t, err, "rate limit b: burst must be at least 1")
+	assert.ErrorContains(t, err, "rate limit c: header is not set")
+	assert.ErrorContains(t, err, `rate limit d: unknown key "user"`)
+}
+
+func TestRateLimitSweep(t *testing.T) {
+	// A bucket with rate 1000 and burst 1 is full after 1ms.
+	bs := newBucketSet(&RateLimit{Rate: 1000, Burst: 1, By: LimitByClientIP})
+	for i := 0; i < 3; i++ {
+		bs.buckets[string(rune('a'+i))] = &bucket{seen: time.Now().Add(-time.Second)}
+	}
+	bs.buckets["recent"] = &bucket{seen: time.Now()}
+	bs.sweep(time.Now())
+	assert.Len(t, bs.buckets, 1)
+	assert.Contains(t, bs.buckets, "recent")
+}
+
+func TestRateLimitMaxBuckets(t *testing.T) {
+	bs := newBucketSet(&RateLimit{Rate: 1, Burst: 1, By: LimitByClientIP})
+	ok, _ := bs.take("10.0.0.0", time.Now())
+	assert.True(t, ok)
+
+	bs.mu.Lock()
+	for i := len(bs.buckets); i < maxBuckets; i++ {
+		bs.buckets[strconv.Itoa(i)] = &bucket{lim: rate.NewLimiter(1, 1), seen: time.Now()}
+	}
+	bs.mu.Unlock()
+
+	// The new keys share the overflow bucket.
+	ok, _ = bs.take("10.0.0.1", time.Now())
+	assert.True(t, ok)
+	ok, _ = bs.take("10.0.0.2", time.Now())
+	assert.False(t, ok)
+	assert.Len(t, bs.buckets, maxBuckets)
+
+	// The periodic sweep frees the space.
+	bs.mu.Lock()
+	for _, b := range bs.buckets {
+		b.seen = time.Now().Add(-time.Hour)
+	}
+	bs.swept = time.Now().Add(-sweepInterval)
+	bs.mu.Unlock()
+	ok, _ = bs.take("10.0.0.3", time.Now())
+	assert.True(t, ok)
+	assert.Len(t, bs.buckets, 1)
+}
+
+func TestLimitState(t *testing.T) {
+	state := &LimitState{}
+	newHandler := func(rate float64, maxInFlight int) http.Handler {
+		s, err := NewSourceE(
+			WithMiddlewareMode(),
+			WithLimits(&Limits{
+				MaxInFlight: maxInFlight,
+				RateLimits:  []*RateLimit{{Name: "default", Rate: rate, Burst: 1}},
+				State:       state,
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+	do := func(h http.Handler) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/persons", nil))
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do(newHandler(0.1, 0)))
+	// A new source with the same limit keeps the empty bucket.
+	assert.Equal(t, http.StatusTooManyRequests, do(newHandler(0.1, 0)))
+	// A changed limit starts with a full bucket.
+	assert.Equal(t, http.StatusOK, do(newHandler(0.2, 0)))
+
+	// The requests in flight are shared.
+	state.inFlight.Add(1)
+	h := newHandler(1e6, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, do(h))
+	state.inFlight.Add(-1)
+	assert.Equal(t, http.StatusOK, do(h))
+}
@@ -0,0 +1,359 @@
+package cewrap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RedactAction is what a redaction rule does with a sensitive value.
+type RedactAction string
+
+const (
+	// RedactMask replaces the value with RedactedMask.
+	RedactMask RedactAction = "mask"
+	// RedactHash replaces the value with the salted SHA-256 hash of the value.
+	RedactHash RedactAction = "hash"
+	// RedactRemove removes the field or header.
+	RedactRemove RedactAction = "remove"
+)
+
+// RedactedMask replaces the masked values.
+const RedactedMask = "****"
+
+// RedactRule selects the sensitive values and the action for them.
+//
+// Paths and Keys select JSON fields, and the query parameters and form fields
+// with the name of a key or a path without dots. Values selects the parts of
+// the JSON string values, parameters and text bodies that match, and Headers
+// selects headers.
+type RedactRule struct {
+	// Paths are dot separated field paths, like "customer.email".
+	// A path that runs into an array applies to every element of the array.
+	Paths []string
+	// Keys are field name patterns that match at any depth, like "*password*".
+	// A * matches any text, the match ignores case.
+	Keys []string
+	// Values match the sensitive parts of string values, like credit card numbers.
+	Values []*regexp.Regexp
+	// Headers are header name patterns, like "Authorization" or "X-Api-*".
+	Headers []string
+	// Action is RedactMask when not set.
+	Action RedactAction
+}
+
+// Redactor removes sensitive data from the event data and the headers
+// before the event is built.
+type Redactor struct {
+	Rules []RedactRule
+	// Salt is prepended to the values that are hashed.
+	Salt string
+}
+
+// RedactBody returns body with the rules applied.
+// JSON bodies are redacted field by field and form bodies parameter by
+// parameter, the value rules also apply to text bodies. Bodies of other
+// types cannot be redacted, they are dropped when a rule selects values in
+// bodies and returned unchanged otherwise.
+func (r *Redactor) RedactBody(contentType string, body []byte) ([]byte, error) {
+	if len(body) == 0 {
+		return body, nil
+	}
+	if isJSON(contentType) {
+		return r.redactJSON(body)
+	}
+	mt, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mt = strings.TrimSpace(mt)
+	switch {
+	case mt == "application/x-www-form-urlencoded":
+		v, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("error redacting form: %w", err)
+		}
+		return []byte(r.RedactQuery(v).Encode()), nil
+	case strings.HasPrefix(mt, "text/"):
+		s := string(body)
+		for _, rule := range r.Rules {
+			for _, re := range rule.Values {
+				s = r.replace(re, s, rule.Action)
+			}
+		}
+		return []byte(s), nil
+	}
+	if r.redactsBodies() {
+		return nil, nil
+	}
+	return body, nil
+}
+
+// redactsBodies reports whether a rule selects values in the bodies.
+func (r *Redactor) redactsBodies() bool {
+	for _, rule := range r.Rules {
+		if len(rule.Paths) > 0 || len(rule.Keys) > 0 || len(rule.Values) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactQuery returns a copy of the query parameters or form fields v with
+// the rules applied.
+func (r *Redactor) RedactQuery(v url.Values) url.Values {
+	res := url.Values{}
+	for name, values := range v {
+		res[name] = append([]string(nil), values...)
+	}
+	for _, rule := range r.Rules {
+		for name, values := range res {
+			if matchAnyFold(rule.Keys, name) || matchAny(topLevelPaths(rule.Paths), name) {
+				switch rule.Action {
+				case RedactRemove:
+					delete(res, name)
+				case RedactHash:
+					for i, s := range values {
+						values[i] = r.hash(s)
+					}
+				default:
+					for i := range values {
+						values[i] = RedactedMask
+					}
+				}
+				continue
+			}
+			for _, re := range rule.Values {
+				for i, s := range values {
+					values[i] = r.replace(re, s, rule.Action)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// topLevelPaths returns the paths without dots, they also select parameters.
+func topLevelPaths(paths []string) []string {
+	var res []string
+	for _, p := range paths {
+		if !strings.Contains(p, ".") {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func (r *Redactor) redactJSON(body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var data any
+	if err := dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("error redacting json: %w", err)
+	}
+	for _, rule := range r.Rules {
+		redact := func(obj map[string]any, key string) {
+			r.redactField(obj, key, rule.Action)
+		}
+		for _, p := range rule.Paths {
+			walkPath(data, splitPath(p), redact)
+		}
+		if len(rule.Keys) > 0 {
+			walkKeys(data, rule.Keys, redact)
+		}
+		for _, re := range rule.Values {
+			data, _ = r.redactValues(data, re, rule.Action)
+		}
+	}
+	return json.Marshal(data)
+}
+
+// RedactHeader returns a copy of h with the rules applied.
+func (r *Redactor) RedactHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	h = h.Clone()
+	for _, rule := range r.Rules {
+		if len(rule.Headers) == 0 {
+			continue
+		}
+		for name, values := range h {
+			if !matchAnyFold(rule.Headers, name) {
+				continue
+			}
+			switch rule.Action {
+			case RedactRemove:
+				delete(h, name)
+			case RedactHash:
+				for i, v := range values {
+					values[i] = r.hash(v)
+				}
+			default:
+				for i := range values {
+					values[i] = RedactedMask
+				}
+			}
+		}
+	}
+	return h
+}
+
+// redactInfo applies the rules to the bodies, the headers and the query in info.
+func (r *Redactor) redactInfo(info *EventInfo) error {
+	body, err := r.RedactBody(info.ContentType, info.ResponseBody)
+	if err != nil {
+		return err
+	}
+	info.ResponseBody = body
+	if body, err = r.RedactBody(requestContentType(info), info.RequestBody); err != nil {
+		return err
+	}
+	info.RequestBody = body
+	info.ResponseHeader = r.RedactHeader(info.ResponseHeader)
+	if info.Request != nil {
+		req := info.Request.Clone(info.Request.Context())
+		req.Header = r.RedactHeader(info.Request.Header)
+		if req.URL.RawQuery != "" {
+			req.URL.RawQuery = r.RedactQuery(req.URL.Query()).Encode()
+			req.RequestURI = req.URL.RequestURI()
+		}
+		info.Request = req
+	}
+	return nil
+}
+
+func (r *Redactor) redactField(obj map[string]any, key string, action RedactAction) {
+	switch action {
+	case RedactRemove:
+		delete(obj, key)
+	case RedactHash:
+		v := obj[key]
+		s, ok := v.(string)
+		if !ok {
+			b, _ := json.Marshal(v)
+			s = string(b)
+		}
+		obj[key] = r.hash(s)
+	default:
+		obj[key] = RedactedMask
+	}
+}
+
+// redactValues applies re to the string values in v.
+// It returns false when the value is removed.
+func (r *Redactor) redactValues(v any, re *regexp.Regexp, action RedactAction) (any, bool) {
+	switch vv := v.(type) {
+	case string:
+		if !re.MatchString(vv) {
+			return vv, true
+		}
+		if action == RedactRemove {
+			return nil, false
+		}
+		return r.replace(re, vv, action), true
+	case json.Number:
+		if !re.MatchString(vv.String()) {
+			return vv, true
+		}
+		if action == RedactRemove {
+			return nil, false
+		}
+		return r.replace(re, vv.String(), action), true
+	case map[string]any:
+		for k, fv := range vv {
+			nv, keep := r.redactValues(fv, re, action)
+			if keep {
+				vv[k] = nv
+			} else {
+				delete(vv, k)
+			}
+		}
+		return vv, true
+	case []any:
+		res := vv[:0]
+		for _, e := range vv {
+			if nv, keep := r.redactValues(e, re, action); keep {
+				res = append(res, nv)
+			}
+		}
+		return res, true
+	default:
+		return v, true
+	}
+}
+
+// replace replaces the parts of s that match re.
+func (r *Redactor) replace(re *regexp.Regexp, s string, action RedactAction) string {
+	switch action {
+	case RedactRemove:
+		return re.ReplaceAllString(s, "")
+	case RedactHash:
+		return re.ReplaceAllStringFunc(s, r.hash)
+	default:
+		return re.ReplaceAllLiteralString(s, RedactedMask)
+	}
+}
+
+// hash returns the salted SHA-256 hash of s.
+func (r *Redactor) hash(s string) string {
+	sum := sha256.Sum256([]byte(r.Salt + s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// walkPath calls fn for the field at path in v.
+func walkPath(v any, path []string, fn func(obj map[string]any, key string)) {
+	switch vv := v.(type) {
+	case map[string]any:
+		fv, ok := vv[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			fn(vv, path[0])
+			return
+		}
+		walkPath(fv, path[1:], fn)
+	case []any:
+		for _, e := range vv {
+			walkPath(e, path, fn)
+		}
+	}
+}
+
+// walkKeys calls fn for the fields with a name that matches one of the patterns.
+// It does not descend into the matching fields.
+func walkKeys(v any, patterns []string, fn func(obj map[string]any, key string)) {
+	switch vv := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if matchAnyFold(patterns, k) {
+				fn(vv, k)
+				continue
+			}
+			walkKeys(vv[k], patterns, fn)
+		}
+	case []any:
+		for _, e := range vv {
+			walkKeys(e, patterns, fn)
+		}
+	}
+}
+
+// matchAnyFold is matchAny ignoring case.
+func matchAnyFold(patterns []string, s string) bool {
+	s = strings.ToLower(s)
+	for _, p := range patterns {
+		if matchGlob(strings.ToLower(p), s) {
+			return true
+		}
+	}
+	return false
+}
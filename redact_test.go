@@ -0,0 +1,183 @@
+package cewrap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactBody(t *testing.T) {
+	const body = `{"email":"jan@example.com","auth":{"accessToken":"abc","Password":"x"},"cards":[{"number":"4111 1111 1111 1111","holder":"Jan"}],"note":"pay NL91ABNA0417164300 today"}`
+	card := regexp.MustCompile(`\b(?:\d[ -]?){13,16}\b`)
+	iban := regexp.MustCompile(`\b[A-Z]{2}\d{2}[A-Z0-9]{10,30}\b`)
+	sum := sha256.Sum256([]byte("salt" + "jan@example.com"))
+	hashed := "sha256:" + hex.EncodeToString(sum[:])
+
+	cases := []struct {
+		name  string
+		rules []RedactRule
+		want  string
+	}{
+		{
+			name:  "mask path and keys",
+			rules: []RedactRule{{Paths: []string{"email"}, Keys: []string{"*token*", "*password*"}}},
+			want:  `{"email":"****","auth":{"accessToken":"****","Password":"****"},"cards":[{"number":"4111 1111 1111 1111","holder":"Jan"}],"note":"pay NL91ABNA0417164300 today"}`,
+		},
+		{
+			name:  "remove path in array",
+			rules: []RedactRule{{Paths: []string{"cards.number", "auth"}, Action: RedactRemove}},
+			want:  `{"email":"jan@example.com","cards":[{"holder":"Jan"}],"note":"pay NL91ABNA0417164300 today"}`,
+		},
+		{
+			name:  "mask values",
+			rules: []RedactRule{{Values: []*regexp.Regexp{card, iban}}},
+			want:  `{"email":"jan@example.com","auth":{"accessToken":"abc","Password":"x"},"cards":[{"number":"****","holder":"Jan"}],"note":"pay **** today"}`,
+		},
+		{
+			name:  "hash path",
+			rules: []RedactRule{{Paths: []string{"email"}, Action: RedactHash}, {Paths: []string{"auth", "cards", "note"}, Action: RedactRemove}},
+			want:  `{"email":"` + hashed + `"}`,
+		},
+	}
+
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			r := &Redactor{Rules: cc.rules, Salt: "salt"}
+			got, err := r.RedactBody("application/json", []byte(body))
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.JSONEq(t, cc.want, string(got))
+		})
+	}
+
+	r := &Redactor{Rules: []RedactRule{{Values: []*regexp.Regexp{iban}}}}
+	got, err := r.RedactBody("text/plain", []byte("pay NL91ABNA0417164300 today"))
+	assert.NoError(t, err)
+	assert.Equal(t, "pay **** today", string(got))
+
+	_, err = r.RedactBody("application/json", []byte("{not json"))
+	assert.Error(t, err)
+
+	// Form bodies are redacted by field, other types are dropped.
+	r = &Redactor{Rules: []RedactRule{{Keys: []string{"*password*"}}, {Paths: []string{"email"}, Action: RedactRemove}}}
+	got, err = r.RedactBody("application/x-www-form-urlencoded; charset=utf-8", []byte("user=jan&password=secret&email=jan%40example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, "password=%2A%2A%2A%2A&user=jan", string(got))
+	got, err = r.RedactBody("multipart/form-data; boundary=x", []byte("--x\r\n..."))
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	// Without body rules the other types are kept.
+	r = &Redactor{Rules: []RedactRule{{Headers: []string{"Authorization"}}}}
+	got, err = r.RedactBody("application/octet-stream", []byte{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, got)
+}
+
+func TestRedactQuery(t *testing.T) {
+	card := regexp.MustCompile(`\b(?:\d[ -]?){13,16}\b`)
+	r := &Redactor{Rules: []RedactRule{
+		{Keys: []string{"*token*"}},
+		{Paths: []string{"email", "customer.email"}, Action: RedactRemove},
+		{Values: []*regexp.Regexp{card}},
+	}}
+	q := url.Values{"access_token": {"abc"}, "email": {"jan@example.com"}, "note": {"card 4111111111111111"}, "page": {"2"}}
+	got := r.RedactQuery(q)
+	assert.Equal(t, url.Values{"access_token": {RedactedMask}, "note": {"card ****"}, "page": {"2"}}, got)
+	// The original is not changed.
+	assert.Equal(t, "abc", q.Get("access_token"))
+}
+
+func TestRedactHeader(t *testing.T) {
+	r := &Redactor{Rules: []RedactRule{
+		{Headers: []string{"authorization"}},
+		{Headers: []string{"X-Api-*"}, Action: RedactRemove},
+	}}
+	h := http.Header{}
+	h.Set("Authorization", "Bearer abc")
+	h.Set("X-Api-Key", "secret")
+	h.Set("Accept", "application/json")
+
+	got := r.RedactHeader(h)
+	assert.Equal(t, RedactedMask, got.Get("Authorization"))
+	assert.Empty(t, got.Get("X-Api-Key"))
+	assert.Equal(t, "application/json", got.Get("Accept"))
+	// The original is not changed.
+	assert.Equal(t, "Bearer abc", h.Get("Authorization"))
+}
+
+func TestSourceRedaction(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1,"password":"x"}`))
+	}))
+	defer svr.Close()
+
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	s, err := NewSourceE(
+		WithDownstream(svr.URL),
+		WithSink(sink),
+		WithSource("urn:test"),
+		WithRedactor(&Redactor{Rules: []RedactRule{{Keys: []string{"password"}, Action: RedactRemove}}}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/persons", bytes.NewBufferString("{}")))
+
+	// The client gets the original response.
+	assert.JSONEq(t, `{"id":1,"password":"x"}`, rr.Body.String())
+
+	var evt cloudevents.Event
+	select {
+	case evt = <-echan:
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	assert.JSONEq(t, `{"id":1}`, string(evt.Data()))
+
+	// The query is redacted in the envelope of data mode both.
+	sink, echan = test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	s, err = NewSourceE(
+		WithDownstream(svr.URL),
+		WithSink(sink),
+		WithSource("urn:test"),
+		WithEventData(EventData{Mode: DataBoth}),
+		WithRedactor(&Redactor{Rules: []RedactRule{{Keys: []string{"password", "token"}}}}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	req := httptest.NewRequest(http.MethodPost, "/login?token=abc&next=%2Fhome", bytes.NewBufferString("user=jan&password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	select {
+	case evt = <-echan:
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	// The form body is base64 encoded in the envelope.
+	form := base64.StdEncoding.EncodeToString([]byte("password=%2A%2A%2A%2A&user=jan"))
+	assert.JSONEq(t, `{
+		"request": {"method": "POST", "path": "/login", "query": {"next": ["/home"], "token": ["****"]}, "body": "`+form+`"},
+		"response": {"status": 200, "body": {"id": 1, "password": "****"}}
+	}`, string(evt.Data()))
+
+	_, err = NewSourceE(WithDownstream(svr.URL), WithRedactor(&Redactor{Rules: []RedactRule{{Action: "blur"}}}))
+	assert.ErrorContains(t, err, `unknown action "blur"`)
+}
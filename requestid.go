@@ -0,0 +1,49 @@
+package cewrap
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultRequestIDHeader is the header that carries the request id.
+	DefaultRequestIDHeader = "X-Request-ID"
+	// RequestIDExtension is the event extension that holds the request id.
+	RequestIDExtension = "requestid"
+
+	// maxRequestIDLength is the max length of an accepted incoming request id.
+	maxRequestIDLength = 128
+)
+
+// requestIDHeader returns the configured request id header or the default.
+func (s *Source) requestIDHeader() string {
+	if s.requestIDHeaderName == "" {
+		return DefaultRequestIDHeader
+	}
+	return s.requestIDHeaderName
+}
+
+// requestID returns the request id from r, or a new one when r has none
+// or when it is not a valid request id.
+func (s *Source) requestID(r *http.Request) string {
+	id := r.Header.Get(s.requestIDHeader())
+	if validRequestID(id) {
+		return id
+	}
+	return uuid.NewString()
+}
+
+// validRequestID only accepts printable ascii without spaces, to keep the
+// logs and the event clean.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
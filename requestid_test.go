@@ -0,0 +1,67 @@
+package cewrap
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	cases := []struct {
+		name     string
+		header   string
+		incoming string
+		generate bool
+	}{
+		{name: "incoming", header: "", incoming: "abc-123"},
+		{name: "generated", header: "", incoming: "", generate: true},
+		{name: "invalid", header: "", incoming: "has spaces", generate: true},
+		{name: "custom header", header: "X-Correlation-ID", incoming: "corr-1"},
+	}
+
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			header := cc.header
+			if header == "" {
+				header = DefaultRequestIDHeader
+			}
+			var downstreamID string
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				downstreamID = r.Header.Get(header)
+				w.Write([]byte("Hi there"))
+			}))
+			defer svr.Close()
+
+			sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+			s := NewSource(
+				WithDownstream(svr.URL),
+				WithSink(sink),
+				WithSource("https://testservice.example.com/testapi"),
+				WithRequestIDHeader(cc.header),
+			)
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/testapi/path", bytes.NewBufferString("Hallo daar"))
+			if cc.incoming != "" {
+				req.Header.Set(header, cc.incoming)
+			}
+			s.Handler()(rr, req)
+			evt := <-echan
+
+			id := rr.Header().Get(header)
+			if cc.generate {
+				assert.NotEmpty(t, id)
+				assert.NotEqual(t, cc.incoming, id)
+			} else {
+				assert.Equal(t, cc.incoming, id)
+			}
+			assert.Equal(t, id, downstreamID)
+			assert.Equal(t, id, evt.Extensions()[RequestIDExtension])
+		})
+	}
+}
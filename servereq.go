@@ -13,18 +13,39 @@ import (
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
 type serviceRequest struct {
 	s      *Source
 	logger *slog.Logger
 
+	// Correlates the request, the downstream call and the event.
+	requestID string
+
 	ctx context.Context
 
+	requestBody  []byte
 	responseBody []byte
 	method       string
 	requestPath  string
 	contentType  string
+	status       int
+
+	// The incoming request and the response headers for the EventInfo.
+	request        *http.Request
+	responseHeader http.Header
+	// The claims of the bearer token.
+	claims map[string]any
+
+	// Data for the access log.
+	bytesIn           int64
+	downstreamLatency time.Duration
+	eventID           string
 }
 
 func (s *serviceRequest) callDownstream(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
@@ -37,18 +58,34 @@ func (s *serviceRequest) callDownstream(ctx context.Context, w http.ResponseWrit
 	if err != nil {
 		return fmt.Errorf("error building downstream request: %w", err)
 	}
-	logger.Info("build the client request",
+	logger.Debug("build the client request",
 		slog.Group("client_request",
 			slog.String("host", cr.Host),
 			slog.String("path", cr.URL.Path),
 		),
 	)
 
-	// Call the downstream service.
+	// Call the downstream service in its own span.
+	ctx, span := s.s.tracer().Start(ctx, "downstream "+cr.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", cr.Method),
+			attribute.String("url.full", cr.URL.String()),
+		),
+	)
+	defer span.End()
+	cr = cr.WithContext(ctx)
+	s.s.textMapPropagator().Inject(ctx, propagation.HeaderCarrier(cr.Header))
+
+	start := time.Now()
 	resp, err := s.s.client.Do(cr)
+	s.downstreamLatency = time.Since(start)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error calling downstream service")
 		return fmt.Errorf("error calling downstream service: %w", err)
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	// Save the body.
 	body, err := io.ReadAll(resp.Body)
@@ -57,7 +94,7 @@ func (s *serviceRequest) callDownstream(ctx context.Context, w http.ResponseWrit
 		return fmt.Errorf("error reading downstream response body: %w", err)
 	}
 
-	logger.Info("called the downstream service")
+	logger.Debug("called the downstream service")
 	// Create the response and write it out to the responseWriter.
 	err = s.writeResponse(w, resp, bytes.NewReader(body))
 	if err != nil {
@@ -72,43 +109,105 @@ func (s *serviceRequest) callDownstream(ctx context.Context, w http.ResponseWrit
 	// Save event data.
 	s.responseBody = body
 	s.contentType = resp.Header.Get("content-type")
+	s.status = resp.StatusCode
+	s.responseHeader = resp.Header
+	s.request = r
 	s.saveRequestData(cr)
 	return nil
 }
 
+// eventInfo returns the metadata of the request for building the event.
+func (s *serviceRequest) eventInfo() *EventInfo {
+	return &EventInfo{
+		Request:        s.request,
+		RequestBody:    s.requestBody,
+		RequestID:      s.requestID,
+		Method:         s.method,
+		Path:           s.requestPath,
+		Claims:         s.claims,
+		Status:         s.status,
+		ResponseHeader: s.responseHeader,
+		ContentType:    s.contentType,
+		ResponseBody:   s.responseBody,
+	}
+}
+
 func (s *serviceRequest) emitEvent(ctx context.Context) error {
-	const typeSuffix = "_handled"
+	info := s.eventInfo()
+	if s.s.redactor != nil {
+		// Do not send the event when the data cannot be redacted.
+		if err := s.s.redactor.redactInfo(info); err != nil {
+			return err
+		}
+	}
+	evtp, err := s.s.eventBuilder().BuildEvent(ctx, info)
+	if err != nil {
+		return fmt.Errorf("error building event: %w", err)
+	}
+	evt := *evtp
+	if evt.ID() == "" {
+		evt.SetID(uuid.NewString())
+	}
+	ok, err := s.prepareEvent(ctx, &evt, info)
+	if err != nil || !ok {
+		return err
+	}
 
-	evt := cloudevents.NewEvent()
-	id, _ := uuid.NewUUID()
-	evt.SetID(id.String())
-	evt.SetSource(s.s.source)
-	evt.SetType(s.s.typePrefix + "." + strings.ToLower(s.method) + typeSuffix)
-	evt.SetSubject(s.requestPath)
-
-	const jsonType = "application/json"
-
-	// Set the data
-	if strings.Index(s.contentType, jsonType) == 0 {
-		// Copied from Event.SetData for data is not a byte array.
-		evt.SetDataContentType(jsonType)
-		evt.DataEncoded = s.responseBody
-		evt.DataBase64 = false
-	} else {
-		evt.SetData(s.contentType, s.responseBody)
-	}
-
-	s.logger.Info("about to send event")
-	return s.sendEvent(ctx, evt)
+	s.eventID = evt.ID()
+	s.logger.Debug("about to send event")
+	return s.sendEvent(ctx, evt, info)
+}
+
+// prepareEvent runs the steps after the event is built: it sets the request
+// id, runs the mutators, applies the overrides and runs the filters.
+// It returns false when a filter vetoes the event.
+func (s *serviceRequest) prepareEvent(ctx context.Context, evt *cloudevents.Event, info *EventInfo) (bool, error) {
+	if s.requestID != "" {
+		evt.SetExtension(RequestIDExtension, s.requestID)
+	}
+
+	// Run the mutators in order.
+	for _, m := range s.s.mutators {
+		if err := m.MutateEvent(ctx, evt, info); err != nil {
+			return false, fmt.Errorf("error mutating event: %w", err)
+		}
+	}
+
+	// The overrides win from the builder and the mutators.
+	if s.s.overrides != nil {
+		for k, v := range s.s.overrides.Extensions {
+			evt.SetExtension(k, v)
+		}
+	}
+
+	// Any filter can veto the event.
+	for _, f := range s.s.filters {
+		if !f.FilterEvent(ctx, evt, info) {
+			s.logger.Debug("event dropped by filter", slog.String("event_id", evt.ID()))
+			return false, nil
+		}
+	}
+	return true, nil
 }
 
-func (s *serviceRequest) sendEvent(ctx context.Context, evt cloudevents.Event) error {
-	evtCtx, evtCancel := context.WithTimeout(ctx, time.Second)
-	defer evtCancel()
-	result := s.s.sink.Send(evtCtx, evt)
+func (s *serviceRequest) sendEvent(ctx context.Context, evt cloudevents.Event, info *EventInfo) error {
+	ctx, span := s.s.tracer().Start(ctx, "send "+evt.Type(),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("cloudevents.event_id", evt.ID()),
+			attribute.String("cloudevents.event_type", evt.Type()),
+			attribute.String("cloudevents.event_subject", evt.Subject()),
+		),
+	)
+	defer span.End()
+
+	// Let consumers link back to this trace.
+	s.s.setTracingExtension(ctx, &evt)
 
-	if !cloudevents.IsACK(result) {
-		return result
+	if err := s.deliver(ctx, evt, info); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "event not acknowledged")
+		return err
 	}
 	return nil
 }
@@ -120,8 +219,13 @@ func (s *serviceRequest) buildDownstreamRequest(ctx context.Context, r *http.Req
 		return nil, err
 	}
 	r.Body.Close()
+	s.bytesIn = int64(len(body))
+	s.requestBody = body
 
 	// Build the downstream path.
+	if s.s.downstream == nil {
+		return nil, errDownstreamNotSet
+	}
 	du, err := url.JoinPath(s.s.downstream.String(), r.URL.Path)
 	if err != nil {
 		return nil, err
@@ -151,6 +255,9 @@ func (s *serviceRequest) writeResponse(w http.ResponseWriter, resp *http.Respons
 		}
 	}
 
+	// The downstream may have replaced the request id.
+	w.Header().Set(s.s.requestIDHeader(), s.requestID)
+
 	// Write the headers with the status code.
 	w.WriteHeader(resp.StatusCode)
 
@@ -178,3 +285,54 @@ func (s *serviceRequest) saveRequestData(r *http.Request) {
 
 	s.method = r.Method
 }
+
+// emitControlEvent sends an event about the handling of r itself, e.g. a
+// rejected request, with the type suffix typ and data, when lim allows it.
+// It runs the same steps as the other events and is sent in the background
+// since the response has been written.
+func (s *serviceRequest) emitControlEvent(r *http.Request, lim *rate.Limiter, typ, path string, data map[string]any) {
+	if len(s.s.sinks) == 0 || !lim.Allow() {
+		return
+	}
+	evt := s.s.newControlEvent(typ, path, data)
+	info := &EventInfo{Request: r, RequestID: s.requestID, Method: r.Method, Path: path}
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		ok, err := s.prepareEvent(ctx, &evt, info)
+		if err == nil && ok {
+			err = s.sendEvent(ctx, evt, info)
+		}
+		if err != nil {
+			s.logger.Error("error sending "+typ+" event", slog.String("err", err.Error()))
+		}
+	}()
+}
+
+// newControlEvent returns an event of the source with the type suffix typ.
+func (s *Source) newControlEvent(typ, subject string, data map[string]any) cloudevents.Event {
+	evt := cloudevents.NewEvent()
+	evt.SetID(uuid.NewString())
+	evt.SetSource(s.source)
+	evt.SetType(s.typePrefix + "." + typ)
+	evt.SetSubject(subject)
+	evt.SetTime(time.Now())
+	evt.SetData(cloudevents.ApplicationJSON, data)
+	return evt
+}
+
+// EmitEvent sends an event with the type suffix typ that is not about a
+// request, e.g. about a reload of the configuration. Like the other events it
+// passes the mutators, gets the overrides and passes the filters.
+func (s *Source) EmitEvent(ctx context.Context, typ, subject string, data map[string]any) error {
+	if len(s.sinks) == 0 {
+		return nil
+	}
+	svcReq := &serviceRequest{s: s, logger: s.logger}
+	evt := s.newControlEvent(typ, subject, data)
+	info := &EventInfo{}
+	ok, err := svcReq.prepareEvent(ctx, &evt, info)
+	if err != nil || !ok {
+		return err
+	}
+	return svcReq.sendEvent(ctx, evt, info)
+}
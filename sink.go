@@ -0,0 +1,170 @@
+package cewrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/protocol"
+)
+
+// Sender sends events to a sink.
+// A cloudevents.Client is a Sender.
+type Sender interface {
+	Send(ctx context.Context, event cloudevents.Event) protocol.Result
+}
+
+// DefaultSinkTimeout is the timeout for sending an event when the sink has none.
+const DefaultSinkTimeout = time.Second
+
+// defaultSinkName is the name of the sink set with WithSink.
+const defaultSinkName = "default"
+
+// Sink is a destination for the events with its own delivery settings.
+type Sink struct {
+	// Name identifies the sink in the logs and errors.
+	Name string
+	// Sender sends the events.
+	Sender Sender
+	// Filters route the events, the sink only gets the events for which all filters return true.
+	Filters []EventFilter
+	// Timeout for sending one event, DefaultSinkTimeout when 0.
+	Timeout time.Duration
+	// Retries is the number of extra attempts when sending fails.
+	Retries int
+	// RetryDelay is the delay before the first retry, it doubles for every next retry.
+	RetryDelay time.Duration
+}
+
+// accepts returns true when all filters of the sink accept evt.
+func (si *Sink) accepts(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool {
+	for _, f := range si.Filters {
+		if !f.FilterEvent(ctx, evt, info) {
+			return false
+		}
+	}
+	return true
+}
+
+// send sends evt with the delivery settings of the sink.
+func (si *Sink) send(ctx context.Context, evt cloudevents.Event) error {
+	timeout := si.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSinkTimeout
+	}
+	delay := si.RetryDelay
+	var result error
+	for attempt := 0; attempt <= si.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		evtCtx, evtCancel := context.WithTimeout(ctx, timeout)
+		result = si.Sender.Send(evtCtx, evt)
+		evtCancel()
+		if cloudevents.IsACK(result) {
+			return nil
+		}
+	}
+	return result
+}
+
+// deliver sends evt to every sink that accepts it.
+// The sinks are independent, a failing sink does not stop the delivery to the others.
+func (s *serviceRequest) deliver(ctx context.Context, evt cloudevents.Event, info *EventInfo) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for i := range s.s.sinks {
+		si := &s.s.sinks[i]
+		if !si.accepts(ctx, &evt, info) {
+			s.logger.Debug("event not routed to sink", slog.String("sink", si.Name))
+			continue
+		}
+		wg.Add(1)
+		// Every sink gets its own copy, senders may change the event.
+		go func(evt cloudevents.Event) {
+			defer wg.Done()
+			if err := si.send(ctx, evt); err != nil {
+				s.logger.Error("error sending event to sink",
+					slog.String("sink", si.Name),
+					slog.String("err", err.Error()),
+				)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("sink %s: %w", si.Name, err))
+				mu.Unlock()
+			}
+		}(evt.Clone())
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// TypeFilter returns a filter that accepts the events with a type that
+// matches one of the patterns. A * in a pattern matches any text.
+func TypeFilter(patterns ...string) EventFilter {
+	return EventFilterFunc(func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool {
+		return matchAny(patterns, evt.Type())
+	})
+}
+
+// SubjectFilter returns a filter that accepts the events with a subject that
+// matches one of the patterns. A * in a pattern matches any text.
+func SubjectFilter(patterns ...string) EventFilter {
+	return EventFilterFunc(func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool {
+		return matchAny(patterns, evt.Subject())
+	})
+}
+
+// ExtensionFilter returns a filter that accepts the events with extension
+// name set to a value that matches pattern. A * in the pattern matches any text.
+func ExtensionFilter(name, pattern string) EventFilter {
+	return EventFilterFunc(func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) bool {
+		v, ok := evt.Extensions()[name]
+		if !ok {
+			return false
+		}
+		return matchGlob(pattern, fmt.Sprint(v))
+	})
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if matchGlob(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches s against pattern, where * matches any text.
+func matchGlob(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, p)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(p):]
+	}
+	return strings.HasSuffix(s, last) && len(s) >= len(last)
+}
@@ -0,0 +1,95 @@
+package cewrap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/client"
+	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/cloudevents/sdk-go/v2/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingSender fails the first failures sends.
+type failingSender struct {
+	failures int32
+	calls    atomic.Int32
+}
+
+func (f *failingSender) Send(ctx context.Context, evt cloudevents.Event) protocol.Result {
+	if f.calls.Add(1) <= f.failures {
+		return errors.New("broker unavailable")
+	}
+	return protocol.ResultACK
+}
+
+func TestSinks(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Hi there"))
+	}))
+	defer svr.Close()
+
+	broker, brokerEvents := test.NewMockSenderClient(t, 2, client.WithUUIDs(), client.WithTimeNow())
+	audit, auditEvents := test.NewMockSenderClient(t, 2, client.WithUUIDs(), client.WithTimeNow())
+	failing := &failingSender{failures: 100}
+	retried := &failingSender{failures: 1}
+
+	s, err := NewSourceE(
+		WithDownstream(svr.URL),
+		WithSource("https://testservice.example.com/testapi"),
+		WithTypePrefix("com.example"),
+		WithSinks(
+			Sink{Name: "broker", Sender: broker},
+			Sink{Name: "audit", Sender: audit, Filters: []EventFilter{TypeFilter("com.example.delete_*")}},
+			Sink{Name: "failing", Sender: failing},
+			Sink{Name: "retried", Sender: retried, Retries: 2, RetryDelay: time.Millisecond},
+		),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, m := range []string{http.MethodPost, http.MethodDelete} {
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, httptest.NewRequest(m, "/persons/1", bytes.NewBufferString("Hallo daar")))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	// The failing sink does not stop the delivery to the others.
+	assert.Equal(t, "com.example.post_handled", (<-brokerEvents).Type())
+	assert.Equal(t, "com.example.delete_handled", (<-brokerEvents).Type())
+	assert.Equal(t, "com.example.delete_handled", (<-auditEvents).Type())
+	select {
+	case evt := <-auditEvents:
+		t.Errorf("audit sink got unrouted event: %s", evt.Type())
+	default:
+	}
+	assert.Equal(t, int32(2), failing.calls.Load())
+	assert.Equal(t, int32(3), retried.calls.Load())
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"com.example.post_handled", "com.example.post_handled", true},
+		{"com.example.*", "com.example.post_handled", true},
+		{"*_handled", "com.example.post_handled", true},
+		{"com.*.post_*", "com.example.post_handled", true},
+		{"com.*.put_*", "com.example.post_handled", false},
+		{"/health*", "/persons", false},
+		{"a*a", "a", false},
+		{"*", "", true},
+	}
+	for _, cc := range cases {
+		assert.Equal(t, cc.want, matchGlob(cc.pattern, cc.s), "%s %s", cc.pattern, cc.s)
+	}
+}
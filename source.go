@@ -1,19 +1,26 @@
 package cewrap
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Source struct {
 	// The downstream service.
 	downstream *url.URL
-	// sink is the url that sinks the events
-	sink cloudevents.Client
+	// sinks receive the events.
+	sinks []Sink
 	// HTTP client for sending the downstream requests.
 	client *http.Client
 	// Methods that indicate a change and will generate an event.
@@ -29,8 +36,58 @@ type Source struct {
 	dataschema string
 
 	logger *slog.Logger
+	// Log the per request records of one in logSampling requests, log all when 0 or 1.
+	logSampling uint64
+	// Counts the requests for the log sampling.
+	logSampleCount atomic.Uint64
+
+	// Header that carries the request id, DefaultRequestIDHeader when empty.
+	requestIDHeaderName string
+
+	// Access log for the proxied requests, disabled when nil.
+	accessLog *AccessLog
+
+	// Tracer provider for the spans, uses the global provider when nil.
+	tracerProvider trace.TracerProvider
+	// Propagator for the trace context of incoming and downstream requests.
+	propagator propagation.TextMapPropagator
+
+	// Builds the events, a DefaultEventBuilder is used when nil.
+	builder EventBuilder
+	// Mutators change the events in order before they are sent.
+	mutators []EventMutator
+	// Filters can veto sending an event.
+	filters []EventFilter
+	// Removes sensitive data before the event is built, nil when not set.
+	redactor *Redactor
+	// Configures the data of the events built by the DefaultEventBuilder.
+	eventData EventData
+	// Overrides applied to every event, nil when not set.
+	overrides *CEOverrides
+	// Checks the bearer tokens before the requests are handled, nil when not set.
+	jwtAuth *JWTAuth
+	// Limits the requests before they are handled, nil when not set.
+	limits *Limits
+	// Blocks the requests to paths that are not exposed, nil when not set.
+	access *AccessControl
+	// Answers the preflights and sets the CORS headers, nil when not set.
+	cors *CORS
+	// Limits the size and the media type of the request bodies, nil when not set.
+	bodyLimits *BodyLimits
+
+	// The errors of the invalid security options, the requests are
+	// rejected when set.
+	failClosed error
+
+	// The source is only used as middleware and has no downstream.
+	middlewareMode bool
+	// Handler for ServeHTTP, created on first use.
+	serveOnce sync.Once
+	serve     http.HandlerFunc
 }
 
+var errDownstreamNotSet = errors.New("downstream not set")
+
 var DefaultChangeMethods = []string{
 	http.MethodPost,
 	http.MethodDelete,
@@ -39,6 +96,10 @@ var DefaultChangeMethods = []string{
 }
 
 func (s *Source) isChange(method string) bool {
+	// Preflights and other OPTIONS requests never change anything.
+	if method == http.MethodOptions {
+		return false
+	}
 	for _, m := range s.changeMethods {
 		if method == m {
 			return true
@@ -47,10 +108,64 @@ func (s *Source) isChange(method string) bool {
 	return false
 }
 
+// knownMethods are the methods that can be used as change methods,
+// OPTIONS is left out since it never changes anything.
+var knownMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodTrace,
+}
+
+func isKnownMethod(method string) bool {
+	for _, m := range knownMethods {
+		if method == m {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSource creates a Source with the options.
+//
+// Invalid options are logged and ignored, use NewSourceE to get the errors.
+// Invalid security options, WithJWTAuth, WithAccessControl and WithRedactor,
+// are not ignored: the source then rejects all requests with 503 instead of
+// serving them without the protection.
 func NewSource(options ...SourceOption) *Source {
+	s, err := newSource(options...)
+	if err != nil {
+		s.logger.Error("invalid source options", slog.String("err", err.Error()))
+	}
+	return s
+}
+
+// NewSourceE creates a Source with the options and returns an error when
+// an option is invalid, when options conflict or when the downstream is not set.
+func NewSourceE(options ...SourceOption) (*Source, error) {
+	s, err := newSource(options...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// newSource applies the options and the defaults, and returns the source
+// together with the errors of the options.
+func newSource(options ...SourceOption) (*Source, error) {
 	s := &Source{}
+	var errs []error
 	for _, opt := range options {
-		opt.apply(s)
+		if err := opt.apply(s); err != nil {
+			errs = append(errs, err)
+			if _, ok := opt.(securityOption); ok {
+				s.failClosed = errors.Join(s.failClosed, err)
+			}
+		}
 	}
 
 	if s.client == nil {
@@ -64,11 +179,30 @@ func NewSource(options ...SourceOption) *Source {
 			slog.String("service", "Source"),
 		)
 	}
-	return s
+
+	if s.downstream == nil && !s.middlewareMode {
+		errs = append(errs, errDownstreamNotSet)
+	}
+	if len(s.sinks) == 0 {
+		s.logger.Warn("no sink set, events are not emitted")
+	}
+	for _, m := range s.mutators {
+		if em, ok := m.(extensionMapper); ok {
+			for _, mp := range em {
+				warnExtensionName(s.logger, mp.Extension)
+			}
+		}
+	}
+	if s.overrides != nil {
+		for name := range s.overrides.Extensions {
+			warnExtensionName(s.logger, name)
+		}
+	}
+	return s, errors.Join(errs...)
 }
 
 func (s *Source) isEmitEvent(method string) bool {
-	return s.sink != nil && s.isChange(method)
+	return len(s.sinks) > 0 && s.isChange(method)
 }
 
 // Handler returns a HandlerFunc that handles the requests.
@@ -76,40 +210,160 @@ func (s *Source) isEmitEvent(method string) bool {
 // It passes the request to the downstream service and generates a cloud event
 // and sends it to the sink.
 func (s *Source) Handler() http.HandlerFunc {
+	return s.handler(nil)
+}
+
+// ServeHTTP passes the request to the downstream service and emits the event,
+// like the HandlerFunc from Handler.
+func (s *Source) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serveOnce.Do(func() { s.serve = s.handler(nil) })
+	s.serve(w, r)
+}
+
+// handler returns the HandlerFunc that passes the requests to next,
+// or to the downstream service when next is nil, and emits the events.
+func (s *Source) handler(next http.Handler) http.HandlerFunc {
 	// Initialize the variables common to all requests.
 	logger := s.logger.With(slog.String("operation", "Handle"))
+	tracer := s.tracer()
+	spanPrefix := "proxy "
+	if next != nil {
+		spanPrefix = "handle "
+	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := s.sampleLogger(logger)
 		defer func(start time.Time) {
-			logger.Info("Handle served", slog.Duration("duration", time.Since(start)))
+			logger.Debug("Handle served", slog.Duration("duration", time.Since(start)))
 		}(time.Now())
 
+		// Do not serve the requests without the protection of an invalid security option.
+		if s.failClosed != nil {
+			logger.Error("request rejected, invalid security options", slog.String("err", s.failClosed.Error()))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		// Correlate the request, the downstream call and the event.
+		requestID := s.requestID(r)
+		r.Header.Set(s.requestIDHeader(), requestID)
+		w.Header().Set(s.requestIDHeader(), requestID)
+
 		// Create and init a serviceRequest.
 		svcReq := &serviceRequest{}
-		svcReq.logger = logger.With(slog.String("request", r.URL.Path))
+		svcReq.logger = logger.With(
+			slog.String("request", r.URL.Path),
+			slog.String("request_id", requestID),
+		)
 		svcReq.s = s
+		svcReq.requestID = requestID
 
-		ctx := r.Context()
-		err := svcReq.callDownstream(ctx, w, r)
-		if err != nil {
+		// Record the response for the access log, CORS and the events.
+		sw := &statusWriter{ResponseWriter: w}
+		w = sw
+		if s.accessLog != nil {
+			defer s.logAccess(svcReq, sw, r, time.Now())
+		}
+		defer sw.finish()
+
+		// Continue the trace of the caller.
+		ctx := s.textMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, spanPrefix+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("http.request.id", requestID),
+			),
+		)
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		// Answer the preflights and set the CORS headers, also on the rejections below.
+		if s.cors != nil {
+			if isPreflight(r) {
+				if !s.cors.preflight(w, r) {
+					logger.Debug("cors preflight not allowed", slog.String("origin", r.Header.Get("Origin")))
+				}
+				return
+			}
+			origin := r.Header.Get("Origin")
+			sw.beforeHeader = func(h http.Header) { s.cors.responseHeaders(h, origin) }
+		}
+
+		// Reject the requests over the limits before they are handled.
+		if s.limits != nil {
+			release, ok := svcReq.limit(w, r)
+			if !ok {
+				span.SetStatus(codes.Error, "limited")
+				return
+			}
+			defer release()
+		}
+
+		// Block the requests to the paths that are not exposed.
+		if s.access != nil && !svcReq.checkAccess(w, r) {
+			span.SetStatus(codes.Error, "blocked")
+			return
+		}
+
+		// Reject the requests with an invalid token before they are handled.
+		if s.jwtAuth != nil {
+			claims, err := s.jwtAuth.authenticate(r)
+			if errors.Is(err, errKeysUnavailable) {
+				logger.Error("request rejected, key set not available", slog.String("err", err.Error()))
+				span.SetStatus(codes.Error, "key set not available")
+				w.Header().Set("Retry-After", strconv.Itoa(int(jwksRetryInterval.Seconds())))
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			if err != nil {
+				logger.Warn("unauthorized request", slog.String("err", err.Error()))
+				span.SetStatus(codes.Error, "unauthorized")
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			svcReq.claims = claims
+		}
+
+		// Reject the request bodies that are too large or of another type.
+		if s.bodyLimits != nil && !svcReq.checkBody(w, r) {
+			span.SetStatus(codes.Error, "body rejected")
+			return
+		}
+
+		if next != nil {
+			svcReq.callNext(sw, r, next)
+		} else if err := svcReq.callDownstream(ctx, w, r); err != nil {
+			var mbe *http.MaxBytesError
+			if errors.As(err, &mbe) {
+				logger.Warn("request body too large", slog.Int64("max", mbe.Limit))
+				span.SetStatus(codes.Error, "body too large")
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
 			// write error
 			w.WriteHeader(http.StatusInternalServerError)
 			logger.Error("error calling downstream", slog.String("err", err.Error()))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "error calling downstream")
 			return
 		}
-		logger.Info("successfully proxied request")
+		logger.Debug("successfully proxied request")
 
 		// Check if an event needs to be emitted.
 		if !s.isEmitEvent(r.Method) {
-			logger.Info("skip emitting event")
+			logger.Debug("skip emitting event")
 			return
 		}
 		// Emit the event.
-		logger.Info("emitting event")
-		err = svcReq.emitEvent(ctx)
+		logger.Debug("emitting event")
+		err := svcReq.emitEvent(ctx)
 		if err != nil {
 			logger.Error("emitEvent failed", slog.String("err", err.Error()))
+			span.RecordError(err)
 		}
-		logger.Info("emitted event")
+		logger.Debug("emitted event")
 	}
 }
@@ -1,40 +1,96 @@
 package cewrap
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
 
-	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
+// SourceOption configures a Source.
+// NewSourceE returns the errors of the options that are not valid.
 type SourceOption interface {
-	apply(s *Source)
+	apply(s *Source) error
 }
 
 type downStream string
 
-func (ds downStream) apply(s *Source) {
-	if uu, err := url.Parse(string(ds)); err == nil {
-		s.downstream = uu
+func (ds downStream) apply(s *Source) error {
+	uu, err := url.Parse(string(ds))
+	if err != nil {
+		return fmt.Errorf("invalid downstream: %w", err)
 	}
+	if uu.Scheme == "" || uu.Host == "" {
+		return fmt.Errorf("invalid downstream: %q is not an absolute url", string(ds))
+	}
+	if s.downstream != nil && s.downstream.String() != uu.String() {
+		return fmt.Errorf("conflicting downstreams: %s and %s", s.downstream, uu)
+	}
+	s.downstream = uu
+	return nil
 }
 func WithDownstream(u string) SourceOption {
 	return downStream(u)
 }
 
-type sink struct{ c cloudevents.Client }
+type sink struct{ c Sender }
+
+func (si sink) apply(s *Source) error {
+	if si.c == nil {
+		return errors.New("sink is nil")
+	}
+	for _, ss := range s.sinks {
+		if ss.Name == defaultSinkName && ss.Sender != si.c {
+			return errors.New("conflicting sinks: sink set more than once")
+		}
+	}
+	s.sinks = append(s.sinks, Sink{Name: defaultSinkName, Sender: si.c})
+	return nil
+}
+
+// WithSink sends the events to s, a cloudevents.Client for example.
+func WithSink(s Sender) SourceOption { return &sink{c: s} }
+
+type sinks []Sink
 
-func (si sink) apply(s *Source) { s.sink = si.c }
+func (o sinks) apply(s *Source) error {
+	var errs []error
+	for i, si := range o {
+		if si.Name == "" {
+			si.Name = fmt.Sprintf("sink-%d", len(s.sinks))
+		}
+		if si.Sender == nil {
+			errs = append(errs, fmt.Errorf("sinks[%d]: sender is nil", i))
+			continue
+		}
+		for _, ss := range s.sinks {
+			if ss.Name == si.Name {
+				errs = append(errs, fmt.Errorf("sinks[%d]: duplicate sink name %q", i, si.Name))
+			}
+		}
+		s.sinks = append(s.sinks, si)
+	}
+	return errors.Join(errs...)
+}
 
-func WithSink(s cloudevents.Client) SourceOption { return &sink{c: s} }
+// WithSinks sends the events to several sinks, each with its own delivery
+// settings and filters. A failing sink does not affect the others.
+func WithSinks(si ...Sink) SourceOption {
+	return sinks(si)
+}
 
 type httpClient struct {
 	c *http.Client
 }
 
-func (c *httpClient) apply(s *Source) {
+func (c *httpClient) apply(s *Source) error {
 	s.client = c.c
+	return nil
 }
 func WithHTTPClient(c *http.Client) SourceOption {
 	return &httpClient{c: c}
@@ -42,8 +98,20 @@ func WithHTTPClient(c *http.Client) SourceOption {
 
 type changeMethods []string
 
-func (c changeMethods) apply(s *Source) {
+func (c changeMethods) apply(s *Source) error {
+	var errs []error
+	for _, m := range c {
+		if m == http.MethodOptions {
+			errs = append(errs, errors.New("OPTIONS cannot be a change method"))
+		} else if !isKnownMethod(m) {
+			errs = append(errs, fmt.Errorf("unknown change method %q", m))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
 	s.changeMethods = c
+	return nil
 }
 func WithChangeMethods(m []string) SourceOption {
 	return changeMethods(m)
@@ -51,35 +119,354 @@ func WithChangeMethods(m []string) SourceOption {
 
 type source string
 
-func (ss source) apply(s *Source) { s.source = string(ss) }
+func (ss source) apply(s *Source) error {
+	s.source = string(ss)
+	return nil
+}
 func WithSource(v string) SourceOption {
 	return source(v)
 }
 
 type prefix string
 
-func (p prefix) apply(s *Source) { s.typePrefix = string(p) }
+func (p prefix) apply(s *Source) error {
+	s.typePrefix = string(p)
+	return nil
+}
 func WithTypePrefix(v string) SourceOption {
 	return prefix(v)
 }
 
 type pathPrefix string
 
-func (p pathPrefix) apply(s *Source) { s.pathPrefix = string(p) }
+func (p pathPrefix) apply(s *Source) error {
+	s.pathPrefix = string(p)
+	return nil
+}
 func WithPathPrefix(v string) SourceOption {
 	return pathPrefix(v)
 }
 
 type dataSchema string
 
-func (d dataSchema) apply(s *Source) { s.dataschema = string(d) }
+func (d dataSchema) apply(s *Source) error {
+	s.dataschema = string(d)
+	return nil
+}
 func WithDataschema(v string) SourceOption {
 	return dataSchema(v)
 }
 
 type loggerOption struct{ l *slog.Logger }
 
-func (l loggerOption) apply(s *Source) { s.logger = l.l }
+func (l loggerOption) apply(s *Source) error {
+	s.logger = l.l
+	return nil
+}
 func WithLogger(l *slog.Logger) SourceOption {
 	return loggerOption{l: l}
 }
+
+type logSampling uint64
+
+func (l logSampling) apply(s *Source) error {
+	s.logSampling = uint64(l)
+	return nil
+}
+
+// WithLogSampling logs the debug and info records of one in n requests.
+// Warnings and errors are always logged.
+func WithLogSampling(n uint64) SourceOption {
+	return logSampling(n)
+}
+
+type tracerProviderOption struct{ tp trace.TracerProvider }
+
+func (t tracerProviderOption) apply(s *Source) error {
+	s.tracerProvider = t.tp
+	return nil
+}
+
+// WithTracerProvider sets the provider for the spans of the proxy,
+// the downstream call and the sink send.
+func WithTracerProvider(tp trace.TracerProvider) SourceOption {
+	return tracerProviderOption{tp: tp}
+}
+
+type propagatorOption struct{ p propagation.TextMapPropagator }
+
+func (p propagatorOption) apply(s *Source) error {
+	s.propagator = p.p
+	return nil
+}
+
+// WithPropagator sets the propagator that extracts the trace context from the
+// incoming request and injects it in the downstream request. Without it the
+// global propagator is used, or the DefaultPropagator when none is set.
+func WithPropagator(p propagation.TextMapPropagator) SourceOption {
+	return propagatorOption{p: p}
+}
+
+type requestIDHeader string
+
+func (h requestIDHeader) apply(s *Source) error {
+	s.requestIDHeaderName = string(h)
+	return nil
+}
+
+// WithRequestIDHeader sets the header that carries the request id,
+// DefaultRequestIDHeader is used when not set.
+func WithRequestIDHeader(name string) SourceOption {
+	return requestIDHeader(name)
+}
+
+type accessLogOption struct{ a *AccessLog }
+
+func (a accessLogOption) apply(s *Source) error {
+	s.accessLog = a.a
+	return nil
+}
+
+// WithAccessLog writes an access log record for every proxied request to a.
+func WithAccessLog(a *AccessLog) SourceOption {
+	return accessLogOption{a: a}
+}
+
+type middlewareMode bool
+
+func (m middlewareMode) apply(s *Source) error {
+	s.middlewareMode = bool(m)
+	return nil
+}
+
+// WithMiddlewareMode creates a source that is only used with Middleware,
+// so no downstream is needed.
+func WithMiddlewareMode() SourceOption {
+	return middlewareMode(true)
+}
+
+type eventBuilderOption struct{ b EventBuilder }
+
+func (o eventBuilderOption) apply(s *Source) error {
+	if o.b == nil {
+		return errors.New("event builder is nil")
+	}
+	s.builder = o.b
+	return nil
+}
+
+// WithEventBuilder sets the builder for the events,
+// a DefaultEventBuilder is used when not set.
+func WithEventBuilder(b EventBuilder) SourceOption {
+	return eventBuilderOption{b: b}
+}
+
+type eventMutators []EventMutator
+
+func (o eventMutators) apply(s *Source) error {
+	s.mutators = append(s.mutators, o...)
+	return nil
+}
+
+// WithEventMutators adds mutators that change the events before they are sent.
+// The mutators run in order.
+func WithEventMutators(m ...EventMutator) SourceOption {
+	return eventMutators(m)
+}
+
+type redactorOption struct{ r *Redactor }
+
+func (redactorOption) security() {}
+
+func (o redactorOption) apply(s *Source) error {
+	if o.r == nil {
+		return errors.New("redactor is nil")
+	}
+	for i, rule := range o.r.Rules {
+		switch rule.Action {
+		case "", RedactMask, RedactHash, RedactRemove:
+		default:
+			return fmt.Errorf("redaction rule %d: unknown action %q", i, rule.Action)
+		}
+	}
+	s.redactor = o.r
+	return nil
+}
+
+// WithRedactor removes the sensitive data from the response body and the
+// headers before the event is built.
+func WithRedactor(r *Redactor) SourceOption {
+	return redactorOption{r: r}
+}
+
+type eventDataOption EventData
+
+func (o eventDataOption) apply(s *Source) error {
+	var errs []error
+	switch o.Mode {
+	case "", DataResponse, DataRequest, DataBoth, DataAuto:
+	default:
+		errs = append(errs, fmt.Errorf("unknown data mode %q", o.Mode))
+	}
+	if o.MaxRequestBytes < 0 || o.MaxResponseBytes < 0 {
+		errs = append(errs, errors.New("negative data size limit"))
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	s.eventData = EventData(o)
+	return nil
+}
+
+// WithEventData configures the data of the events built by the
+// DefaultEventBuilder, e.g. to include the request body.
+func WithEventData(d EventData) SourceOption {
+	return eventDataOption(d)
+}
+
+type ceOverridesOption struct{ o *CEOverrides }
+
+func (o ceOverridesOption) apply(s *Source) error {
+	if o.o == nil {
+		return errors.New("ce overrides are nil")
+	}
+	if err := o.o.validate(); err != nil {
+		return err
+	}
+	s.overrides = o.o
+	return nil
+}
+
+// WithCEOverrides sets the extensions in o on every event, after the mutators ran.
+// Use ParseCEOverrides to read the Knative K_CE_OVERRIDES env var.
+func WithCEOverrides(o *CEOverrides) SourceOption {
+	return ceOverridesOption{o: o}
+}
+
+// securityOption is an option that protects the requests or the data.
+// NewSource does not ignore them when they are invalid, see NewSource.
+type securityOption interface {
+	SourceOption
+	security()
+}
+
+type jwtAuthOption struct{ a *JWTAuth }
+
+func (jwtAuthOption) security() {}
+
+func (o jwtAuthOption) apply(s *Source) error {
+	if o.a == nil {
+		return errors.New("jwt auth is nil")
+	}
+	if o.a.Keys == nil && (o.a.Issuer != "" || o.a.Audience != "") {
+		return errors.New("jwt auth: issuer and audience need keys")
+	}
+	// The keys are loaded on the first request, so a key set that is not
+	// available yet does not fail the source.
+	if o.a.Keys != nil && o.a.Keys.File == "" && o.a.Keys.URL == "" {
+		return errors.New("jwt auth: key set file or url not set")
+	}
+	s.jwtAuth = o.a
+	return nil
+}
+
+// WithJWTAuth rejects the requests without a valid bearer JWT with 401
+// before they are handled, and with 503 while the key set is not available.
+// The claims are available in EventInfo.Claims, map them to extensions
+// with ExtensionMapper and FromClaim.
+func WithJWTAuth(a *JWTAuth) SourceOption {
+	return jwtAuthOption{a: a}
+}
+
+type limitsOption struct{ l *Limits }
+
+func (o limitsOption) apply(s *Source) error {
+	if o.l == nil {
+		return errors.New("limits are nil")
+	}
+	if err := o.l.validate(); err != nil {
+		return err
+	}
+	o.l.init()
+	s.limits = o.l
+	return nil
+}
+
+// WithLimits limits the rate and the concurrency of the requests,
+// the requests over a limit are rejected before they are handled.
+func WithLimits(l *Limits) SourceOption {
+	return limitsOption{l: l}
+}
+
+type accessOption struct{ a *AccessControl }
+
+func (accessOption) security() {}
+
+func (o accessOption) apply(s *Source) error {
+	if o.a == nil {
+		return errors.New("access control is nil")
+	}
+	if err := o.a.validate(); err != nil {
+		return err
+	}
+	o.a.eventLimiter = rate.NewLimiter(1, 1)
+	s.access = o.a
+	return nil
+}
+
+// WithAccessControl blocks the requests that the access rules deny with 404
+// or 403 before they are handled, they are not sent downstream.
+func WithAccessControl(a *AccessControl) SourceOption {
+	return accessOption{a: a}
+}
+
+type corsOption struct{ c *CORS }
+
+func (o corsOption) apply(s *Source) error {
+	if o.c == nil {
+		return errors.New("cors is nil")
+	}
+	if err := o.c.validate(); err != nil {
+		return err
+	}
+	s.cors = o.c
+	return nil
+}
+
+// WithCORS answers the CORS preflights without calling the downstream
+// service and sets the CORS headers on the responses.
+func WithCORS(c *CORS) SourceOption {
+	return corsOption{c: c}
+}
+
+type bodyLimitsOption struct{ b *BodyLimits }
+
+func (o bodyLimitsOption) apply(s *Source) error {
+	if o.b == nil {
+		return errors.New("body limits are nil")
+	}
+	if err := o.b.validate(); err != nil {
+		return err
+	}
+	s.bodyLimits = o.b
+	return nil
+}
+
+// WithBodyLimits rejects the requests with a body that is too large with 413
+// and with a body of a media type that is not accepted with 415.
+func WithBodyLimits(b *BodyLimits) SourceOption {
+	return bodyLimitsOption{b: b}
+}
+
+type eventFilters []EventFilter
+
+func (o eventFilters) apply(s *Source) error {
+	s.filters = append(s.filters, o...)
+	return nil
+}
+
+// WithEventFilters adds filters that can veto sending the events.
+// An event is only sent when all filters return true.
+func WithEventFilters(f ...EventFilter) SourceOption {
+	return eventFilters(f)
+}
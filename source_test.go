@@ -12,6 +12,12 @@ import (
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/cloudevents/sdk-go/v2/client"
 	"github.com/cloudevents/sdk-go/v2/client/test"
+	"github.com/cloudevents/sdk-go/v2/extensions"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestHandle(t *testing.T) {
@@ -29,7 +35,7 @@ func TestHandle(t *testing.T) {
 	s := &Source{
 		downstream:    u,
 		client:        &http.Client{},
-		sink:          sink,
+		sinks:         []Sink{{Name: "default", Sender: sink}},
 		changeMethods: DefaultChangeMethods,
 		logger:        slog.Default(),
 		source:        "https://testservice.example.com/testapi",
@@ -112,3 +118,156 @@ func TestHandleWithWith(t *testing.T) {
 	}
 	t.Logf("done, %s", text)
 }
+
+func TestHandleTracing(t *testing.T) {
+	// Create a dummy server that records the trace header.
+	var traceparent string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte("Hi there"))
+	}))
+	defer svr.Close()
+
+	sink, echan := test.NewMockSenderClient(t, 1, client.WithUUIDs(), client.WithTimeNow())
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	s := NewSource(
+		WithDownstream(svr.URL),
+		WithSink(sink),
+		WithSource("https://testservice.example.com/testapi"),
+		WithTracerProvider(tp),
+	)
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/testapi/path", bytes.NewBufferString("Hallo daar"))
+	req.Header.Set("traceparent", incoming)
+	s.Handler()(rr, req)
+
+	var evt cloudevents.Event
+	select {
+	case evt = <-echan:
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("want 3 spans, got %d", len(spans))
+	}
+	for _, sp := range spans {
+		if got := sp.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %s not in incoming trace: %s", sp.Name(), got)
+		}
+	}
+	if traceparent == "" || traceparent == incoming {
+		t.Errorf("downstream traceparent not propagated: %q", traceparent)
+	}
+	dt, ok := extensions.GetDistributedTracingExtension(evt)
+	if !ok {
+		t.Fatalf("event has no traceparent extension")
+	}
+	if dt.TraceParent[3:35] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("event traceparent not in incoming trace: %s", dt.TraceParent)
+	}
+}
+
+func TestGlobalPropagator(t *testing.T) {
+	s := NewSource(WithMiddlewareMode())
+	assert.Equal(t, DefaultPropagator, s.textMapPropagator())
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	defer otel.SetTextMapPropagator(propagation.TraceContext{})
+	assert.Contains(t, s.textMapPropagator().Fields(), "baggage")
+
+	// The propagator of the option wins.
+	s = NewSource(WithMiddlewareMode(), WithPropagator(propagation.TraceContext{}))
+	assert.NotContains(t, s.textMapPropagator().Fields(), "baggage")
+}
+
+func TestNewSourceE(t *testing.T) {
+	sink, _ := test.NewMockSenderClient(t, 1)
+	otherSink, _ := test.NewMockSenderClient(t, 1)
+
+	cases := []struct {
+		name    string
+		options []SourceOption
+		want    []string
+	}{
+		{
+			name:    "ok",
+			options: []SourceOption{WithDownstream("http://example.com"), WithSink(sink)},
+		},
+		{
+			name:    "no sink",
+			options: []SourceOption{WithDownstream("http://example.com")},
+		},
+		{
+			name:    "missing downstream",
+			options: []SourceOption{WithSink(sink)},
+			want:    []string{"downstream not set"},
+		},
+		{
+			name:    "invalid downstream",
+			options: []SourceOption{WithDownstream("example.com/path"), WithSink(sink)},
+			want:    []string{"invalid downstream", "downstream not set"},
+		},
+		{
+			name:    "unparsable downstream",
+			options: []SourceOption{WithDownstream("http://exa mple.com:port")},
+			want:    []string{"invalid downstream"},
+		},
+		{
+			name: "unknown methods",
+			options: []SourceOption{
+				WithDownstream("http://example.com"),
+				WithChangeMethods([]string{"POST", "post", "PUSH"}),
+			},
+			want: []string{`unknown change method "post"`, `unknown change method "PUSH"`},
+		},
+		{
+			name: "conflicting downstreams",
+			options: []SourceOption{
+				WithDownstream("http://example.com"),
+				WithDownstream("http://example.org"),
+			},
+			want: []string{"conflicting downstreams"},
+		},
+		{
+			name: "conflicting sinks",
+			options: []SourceOption{
+				WithDownstream("http://example.com"),
+				WithSink(sink),
+				WithSink(otherSink),
+			},
+			want: []string{"conflicting sinks"},
+		},
+	}
+
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			s, err := NewSourceE(cc.options...)
+			if len(cc.want) == 0 {
+				assert.NoError(t, err)
+				assert.NotNil(t, s)
+				return
+			}
+			if !assert.Error(t, err) {
+				return
+			}
+			assert.Nil(t, s)
+			for _, w := range cc.want {
+				assert.Contains(t, err.Error(), w)
+			}
+		})
+	}
+}
+
+func TestNewSourceWithoutDownstream(t *testing.T) {
+	// The compatibility path must not panic on a missing downstream.
+	s := NewSource(WithDownstream("not a url"))
+	rr := httptest.NewRecorder()
+	s.Handler()(rr, httptest.NewRequest(http.MethodGet, "/path", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
@@ -0,0 +1,53 @@
+package cewrap
+
+import (
+	"context"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/extensions"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name used for the spans of the Source.
+const tracerName = "github.com/myhops/cewrap"
+
+// DefaultPropagator is the propagator used when none is configured and no
+// global propagator is set. It handles the W3C traceparent and tracestate headers.
+var DefaultPropagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// tracer returns the tracer from the configured provider or from the global provider.
+func (s *Source) tracer() trace.Tracer {
+	tp := s.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// textMapPropagator returns the configured propagator, the global propagator
+// when one is set with otel.SetTextMapPropagator, or the DefaultPropagator.
+func (s *Source) textMapPropagator() propagation.TextMapPropagator {
+	if s.propagator != nil {
+		return s.propagator
+	}
+	// The global propagator has no fields until one is set.
+	if p := otel.GetTextMapPropagator(); len(p.Fields()) > 0 {
+		return p
+	}
+	return DefaultPropagator
+}
+
+// setTracingExtension adds the distributed tracing extension to evt,
+// using the span context in ctx.
+func (s *Source) setTracingExtension(ctx context.Context, evt *cloudevents.Event) {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+
+	dt := extensions.DistributedTracingExtension{
+		TraceParent: carrier.Get(extensions.TraceParentExtension),
+		TraceState:  carrier.Get(extensions.TraceStateExtension),
+	}
+	dt.AddTracingAttributes(evt)
+}
@@ -0,0 +1,235 @@
+package cewrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+// DataTransform is a mutator that projects and reshapes the JSON data of an event.
+//
+// A path is a dot separated list of field names, like "address.city".
+// A path that runs into an array applies to every element of the array.
+// A rename moves the field within each element of the arrays on the part
+// that both paths share, like orders.total to orders.amount.
+// The steps run in the order of the fields, and the data of events with a
+// content type other than JSON is not changed.
+type DataTransform struct {
+	// Include keeps only the fields with these paths, all fields when empty.
+	Include []string
+	// Exclude removes the fields with these paths.
+	Exclude []string
+	// Rename moves the fields, in order.
+	Rename []FieldRename
+	// Envelope wraps the data in an object under this field name.
+	Envelope string
+	// Add sets constant top level fields.
+	Add map[string]any
+}
+
+// FieldRename moves the field at the From path to the To path.
+type FieldRename struct {
+	From string
+	To   string
+}
+
+func (t *DataTransform) MutateEvent(ctx context.Context, evt *cloudevents.Event, info *EventInfo) error {
+	if !isJSON(evt.DataContentType()) || len(evt.Data()) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(evt.Data()))
+	dec.UseNumber()
+	var data any
+	if err := dec.Decode(&data); err != nil {
+		return err
+	}
+
+	if len(t.Include) > 0 {
+		data = project(data, pathTree(t.Include))
+	}
+	for _, p := range t.Exclude {
+		exclude(data, splitPath(p))
+	}
+	for _, r := range t.Rename {
+		rename(data, splitPath(r.From), splitPath(r.To))
+	}
+	if t.Envelope != "" {
+		data = map[string]any{t.Envelope: data}
+	}
+	if obj, ok := data.(map[string]any); ok {
+		for k, v := range t.Add {
+			obj[k] = v
+		}
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	// Set the encoded data, SetData would base64 encode the bytes.
+	evt.DataEncoded = b
+	evt.DataBase64 = false
+	return nil
+}
+
+// isJSON returns true for application/json and the +json content types.
+func isJSON(contentType string) bool {
+	mt, _, _ := strings.Cut(contentType, ";")
+	mt = strings.TrimSpace(strings.ToLower(mt))
+	return mt == cloudevents.ApplicationJSON || strings.HasSuffix(mt, "+json")
+}
+
+func splitPath(p string) []string {
+	return strings.Split(p, ".")
+}
+
+// fieldTree holds the included paths, a nil subtree includes the whole field.
+type fieldTree map[string]fieldTree
+
+func pathTree(paths []string) fieldTree {
+	root := fieldTree{}
+	for _, p := range paths {
+		node := root
+		parts := splitPath(p)
+		for i, name := range parts {
+			sub, ok := node[name]
+			if ok && sub == nil {
+				// A shorter path already includes the whole field.
+				break
+			}
+			if i == len(parts)-1 {
+				node[name] = nil
+				break
+			}
+			if !ok {
+				sub = fieldTree{}
+				node[name] = sub
+			}
+			node = sub
+		}
+	}
+	return root
+}
+
+// project returns the fields of v that are in tree.
+func project(v any, tree fieldTree) any {
+	if tree == nil {
+		return v
+	}
+	switch vv := v.(type) {
+	case map[string]any:
+		res := map[string]any{}
+		for name, sub := range tree {
+			if fv, ok := vv[name]; ok {
+				res[name] = project(fv, sub)
+			}
+		}
+		return res
+	case []any:
+		res := make([]any, len(vv))
+		for i, e := range vv {
+			res[i] = project(e, tree)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// exclude removes the field at path from v.
+func exclude(v any, path []string) {
+	switch vv := v.(type) {
+	case map[string]any:
+		if len(path) == 1 {
+			delete(vv, path[0])
+			return
+		}
+		if fv, ok := vv[path[0]]; ok {
+			exclude(fv, path[1:])
+		}
+	case []any:
+		for _, e := range vv {
+			exclude(e, path)
+		}
+	}
+}
+
+// rename moves the field at from to to.
+func rename(v any, from, to []string) {
+	switch vv := v.(type) {
+	case map[string]any:
+		// Follow the shared part of the paths, it can run into arrays.
+		if len(from) > 1 && len(to) > 1 && from[0] == to[0] {
+			if fv, ok := vv[from[0]]; ok {
+				rename(fv, from[1:], to[1:])
+			}
+			return
+		}
+		fv, ok := take(vv, from)
+		if !ok {
+			return
+		}
+		put(vv, to, fv)
+	case []any:
+		for _, e := range vv {
+			rename(e, from, to)
+		}
+	}
+}
+
+// take removes the field at path from obj and returns its value.
+func take(obj map[string]any, path []string) (any, bool) {
+	for _, name := range path[:len(path)-1] {
+		sub, ok := obj[name].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		obj = sub
+	}
+	last := path[len(path)-1]
+	v, ok := obj[last]
+	delete(obj, last)
+	return v, ok
+}
+
+// put sets the field at path in obj, it creates the missing objects.
+func put(obj map[string]any, path []string, v any) {
+	for _, name := range path[:len(path)-1] {
+		sub, ok := obj[name].(map[string]any)
+		if !ok {
+			sub = map[string]any{}
+			obj[name] = sub
+		}
+		obj = sub
+	}
+	obj[path[len(path)-1]] = v
+}
+
+// TransformRoute applies a transform to the events of the requests with a
+// path that matches one of the patterns. A * in a pattern matches any text.
+type TransformRoute struct {
+	Paths     []string
+	Transform *DataTransform
+}
+
+// RouteTransforms returns a mutator that applies the transform of the first
+// route that matches the path of the request, or def when no route matches.
+// def can be nil.
+func RouteTransforms(def *DataTransform, routes ...TransformRoute) EventMutator {
+	return EventMutatorFunc(func(ctx context.Context, evt *cloudevents.Event, info *EventInfo) error {
+		t := def
+		for _, r := range routes {
+			if matchAny(r.Paths, info.Path) {
+				t = r.Transform
+				break
+			}
+		}
+		if t == nil {
+			return nil
+		}
+		return t.MutateEvent(ctx, evt, info)
+	})
+}
@@ -0,0 +1,107 @@
+package cewrap
+
+import (
+	"context"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataTransform(t *testing.T) {
+	const body = `{"id":1,"name":"Jan","address":{"city":"Utrecht","street":"Dam"},"orders":[{"id":7,"total":12.5,"lines":[1,2]}],"secret":"x"}`
+
+	cases := []struct {
+		name        string
+		transform   DataTransform
+		contentType string
+		want        string
+	}{
+		{
+			name:      "include",
+			transform: DataTransform{Include: []string{"id", "address.city", "orders.id"}},
+			want:      `{"address":{"city":"Utrecht"},"id":1,"orders":[{"id":7}]}`,
+		},
+		{
+			name:      "exclude",
+			transform: DataTransform{Exclude: []string{"secret", "address", "orders.lines"}},
+			want:      `{"id":1,"name":"Jan","orders":[{"id":7,"total":12.5}]}`,
+		},
+		{
+			name: "rename, envelope and add",
+			transform: DataTransform{
+				Include:  []string{"id", "address.city"},
+				Rename:   []FieldRename{{From: "address.city", To: "city"}, {From: "id", To: "person.id"}},
+				Envelope: "data",
+				Add:      map[string]any{"version": 2},
+			},
+			want: `{"data":{"address":{},"city":"Utrecht","person":{"id":1}},"version":2}`,
+		},
+		{
+			name:      "rename in an array",
+			transform: DataTransform{Include: []string{"orders"}, Rename: []FieldRename{{From: "orders.total", To: "orders.amount"}, {From: "orders.id", To: "orders.ref.id"}}},
+			want:      `{"orders":[{"ref":{"id":7},"amount":12.5,"lines":[1,2]}]}`,
+		},
+		{
+			name: "renames in order",
+			transform: DataTransform{
+				Include: []string{"id", "name"},
+				Rename:  []FieldRename{{From: "name", To: "id"}, {From: "id", To: "key"}},
+			},
+			want: `{"key":"Jan"}`,
+		},
+		{
+			name:        "json suffix",
+			transform:   DataTransform{Include: []string{"id"}},
+			contentType: "application/vnd.person+json; charset=utf-8",
+			want:        `{"id":1}`,
+		},
+		{
+			name:        "not json",
+			transform:   DataTransform{Include: []string{"id"}},
+			contentType: "text/plain",
+			want:        body,
+		},
+	}
+
+	for _, cc := range cases {
+		t.Run(cc.name, func(t *testing.T) {
+			ct := cc.contentType
+			if ct == "" {
+				ct = cloudevents.ApplicationJSON
+			}
+			evt := cloudevents.NewEvent()
+			evt.SetDataContentType(ct)
+			evt.DataEncoded = []byte(body)
+
+			err := cc.transform.MutateEvent(context.Background(), &evt, &EventInfo{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			if ct == "text/plain" {
+				assert.Equal(t, cc.want, string(evt.Data()))
+				return
+			}
+			assert.JSONEq(t, cc.want, string(evt.Data()))
+			assert.False(t, evt.DataBase64)
+		})
+	}
+}
+
+func TestRouteTransforms(t *testing.T) {
+	m := RouteTransforms(
+		&DataTransform{Envelope: "default"},
+		TransformRoute{Paths: []string{"/persons/*"}, Transform: &DataTransform{Envelope: "person"}},
+		TransformRoute{Paths: []string{"/raw"}},
+	)
+	run := func(path string) string {
+		evt := cloudevents.NewEvent()
+		evt.SetDataContentType(cloudevents.ApplicationJSON)
+		evt.DataEncoded = []byte(`{"id":1}`)
+		assert.NoError(t, m.MutateEvent(context.Background(), &evt, &EventInfo{Path: path}))
+		return string(evt.Data())
+	}
+	assert.JSONEq(t, `{"person":{"id":1}}`, run("/persons/1"))
+	assert.JSONEq(t, `{"default":{"id":1}}`, run("/orders/1"))
+	assert.JSONEq(t, `{"id":1}`, run("/raw"))
+}